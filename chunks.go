@@ -0,0 +1,267 @@
+package pork
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A chunk of a .main.modules manifest, which is built into its own output.
+// A chunk is loaded after the chunks it depends on and can use anything
+// they define.
+type chunk struct {
+	name  string
+	deps  []string
+	files []string
+
+	// the compiled output
+	out []byte
+}
+
+// [name] or [name: dep dep]
+var chunkHeaderPattern = regexp.MustCompile(`^\[\s*([A-Za-z0-9_-]+)\s*(?::([^\]]*))?\]$`)
+
+// Reads the chunks declared in a .main.modules manifest. The manifest is a
+// list of chunks, each a header naming the chunk and the chunks it depends
+// on, followed by the files that make it up, relative to the manifest:
+//
+//	# shared by every page
+//	[base]
+//	lib/util.js
+//
+//	[home: base]
+//	pages/home.js
+//
+// Chunks must come after the chunks they depend on and only the first
+// chunk can be without dependencies.
+func readChunks(filename string) ([]*chunk, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(filename)
+	byName := map[string]*chunk{}
+
+	var chunks []*chunk
+	var cur *chunk
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			m := chunkHeaderPattern.FindStringSubmatch(line)
+			if m == nil {
+				return nil, errorAt(filename, n, "invalid chunk header: %s", line)
+			}
+
+			if byName[m[1]] != nil {
+				return nil, errorAt(filename, n, "duplicate chunk: %s", m[1])
+			}
+
+			cur = &chunk{name: m[1]}
+			cur.deps = append(cur.deps, strings.Fields(m[2])...)
+			for _, dep := range cur.deps {
+				if byName[dep] == nil {
+					return nil, errorAt(filename, n, "%s depends on %s, which is not declared before it",
+						cur.name, dep)
+				}
+			}
+
+			if len(chunks) > 0 && len(cur.deps) == 0 {
+				return nil, errorAt(filename, n, "%s must depend on another chunk", cur.name)
+			}
+
+			byName[cur.name] = cur
+			chunks = append(chunks, cur)
+			continue
+		}
+
+		if cur == nil {
+			return nil, errorAt(filename, n, "%s is not in a chunk", line)
+		}
+
+		cur.files = append(cur.files, filepath.Join(dir, filepath.FromSlash(line)))
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("%s: no chunks", filename)
+	}
+
+	for _, c := range chunks {
+		if len(c.files) == 0 {
+			return nil, fmt.Errorf("%s: chunk %s has no files", filename, c.name)
+		}
+	}
+
+	return chunks, nil
+}
+
+// Splits the path of a chunk's output, <base>.<chunk>.js, into the
+// manifest that declares it and the name of the chunk.
+func splitChunkPath(rel string) (string, string, bool) {
+	base := strings.TrimSuffix(rel, javaScriptFileExtension)
+	i := strings.LastIndex(base, ".")
+	if i <= 0 || i == len(base)-1 || strings.ContainsRune(base[i:], filepath.Separator) {
+		return "", "", false
+	}
+	return base[:i] + modulesFileExtension, base[i+1:], true
+}
+
+// The output path of a chunk of the manifest src.
+func chunkPath(src, name string) string {
+	return changeTypeOfFile(src, modulesFileExtension, "."+name+javaScriptFileExtension)
+}
+
+// Compiles the chunks declared in the manifest src. At Basic and Advanced,
+// closure-compiler optimizes all of the chunks together so that code can
+// move between them. Otherwise each chunk is its files run through the
+// preprocessor, one after the other, and then the usual optimizer.
+func compileChunks(ctx context.Context, c *Config, src string) ([]*chunk, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	chunks, err := readChunks(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Level != None && useClosureCompiler(c) {
+		err = compileChunksWithClosure(ctx, c, src, chunks)
+	} else {
+		err = concatChunks(ctx, c, src, chunks)
+	}
+
+	if errors.Is(err, ErrTimeout) && c.Timeout > 0 {
+		return nil, fmt.Errorf("%s: %w after %s", src, err, c.Timeout)
+	}
+	return chunks, err
+}
+
+func concatChunks(ctx context.Context, c *Config, src string, chunks []*chunk) error {
+	for _, ch := range chunks {
+		var buf bytes.Buffer
+		wo, err := optimizeJs(ctx, c, src, &buf)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range ch.files {
+			if err := streamJs(ctx, c, file, wo); err != nil {
+				wo.Close()
+				return err
+			}
+		}
+
+		if err := wo.Close(); err != nil {
+			return err
+		}
+		ch.out = buf.Bytes()
+	}
+	return nil
+}
+
+func compileChunksWithClosure(ctx context.Context, c *Config, src string, chunks []*chunk) error {
+	dir, err := ioutil.TempDir(os.TempDir(), "jsc-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	prefix := filepath.Join(dir, "out") + string(filepath.Separator)
+	if err := os.MkdirAll(prefix, os.ModePerm); err != nil {
+		return err
+	}
+
+	// closure-compiler only sees the preprocessed files, so its
+	// diagnostics are mapped back to the originals
+	var names []string
+	args := jscArgs(c)
+	for _, ch := range chunks {
+		for _, file := range ch.files {
+			in := filepath.Join(dir, strconv.Itoa(len(names)/2)+javaScriptFileExtension)
+			names = append(names, in, file)
+
+			var buf bytes.Buffer
+			if err := streamJs(ctx, c, file, &buf); err != nil {
+				return err
+			}
+
+			if err := ioutil.WriteFile(in, buf.Bytes(), 0644); err != nil {
+				return err
+			}
+			args = append(args, "--js", in)
+		}
+
+		spec := fmt.Sprintf("%s:%d", ch.name, len(ch.files))
+		if len(ch.deps) > 0 {
+			spec += ":" + strings.Join(ch.deps, ",")
+		}
+		args = append(args, "--chunk", spec)
+	}
+
+	args = append(args, "--chunk_output_path_prefix", prefix)
+	originals := strings.NewReplacer(names...)
+
+	var diags string
+	if c.Workers > 0 {
+		res, err := c.workerPools().jsc.call(ctx, &workerRequest{Src: src, Args: args})
+		if err != nil {
+			return err
+		}
+
+		diags = originals.Replace(res.Diagnostics)
+		if res.Failed {
+			return newCompileError("closure-compiler", src, diags, errWorkerCompile,
+				parseClosureDiagnostics)
+		}
+	} else {
+		jsc, err := closureCompiler()
+		if err != nil {
+			return fmt.Errorf("closure-compiler: %s", err)
+		}
+
+		var out bytes.Buffer
+		cm := exec.CommandContext(ctx, jsc[0], append(jsc[1:], args...)...)
+		cm.Stdout = &out
+		cm.Stderr = &out
+		if err := cm.Run(); err != nil {
+			if ctx.Err() != nil {
+				return contextError(ctx, "closure-compiler")
+			}
+			return newCompileError("closure-compiler", src, originals.Replace(out.String()), err,
+				parseClosureDiagnostics)
+		}
+		diags = originals.Replace(out.String())
+	}
+
+	for _, ch := range chunks {
+		out, err := ioutil.ReadFile(prefix + ch.name + javaScriptFileExtension)
+		if err != nil {
+			return err
+		}
+		ch.out = out
+	}
+
+	return reportWarnings(c, "closure-compiler", src, diags, parseClosureDiagnostics)
+}
@@ -0,0 +1,117 @@
+package pork
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadChunks(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"app.main.modules":        "# shared\n[base]\nlib/a.js\nlib/b.js\n\n[home: base]\nhome.js\n[search : base home]\nsearch.js\n",
+		"undeclared.main.modules": "[base]\na.js\n[home: nope]\nhome.js\n",
+		"orphan.main.modules":     "a.js\n[base]\nb.js\n",
+		"roots.main.modules":      "[base]\na.js\n[other]\nb.js\n",
+		"empty.main.modules":      "[base]\na.js\n[home: base]\n",
+	})
+	defer os.RemoveAll(dir)
+
+	chunks, err := readChunks(filepath.Join(dir, "app.main.modules"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*chunk{
+		{name: "base", files: []string{filepath.Join(dir, "lib/a.js"), filepath.Join(dir, "lib/b.js")}},
+		{name: "home", deps: []string{"base"}, files: []string{filepath.Join(dir, "home.js")}},
+		{name: "search", deps: []string{"base", "home"}, files: []string{filepath.Join(dir, "search.js")}},
+	}
+	if !reflect.DeepEqual(chunks, expected) {
+		t.Fatalf("expected %v, got %v", expected, chunks)
+	}
+
+	for _, name := range []string{"undeclared", "orphan", "roots", "empty"} {
+		if _, err := readChunks(filepath.Join(dir, name+".main.modules")); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSplitChunkPath(t *testing.T) {
+	tests := map[string][]string{
+		"app.base.js":    {"app.main.modules", "base"},
+		"js/app.home.js": {filepath.Join("js", "app.main.modules"), "home"},
+		"app.js":         nil,
+		"dir.x/app.js":   nil,
+		".hidden.js":     nil,
+		"app..js":        nil,
+	}
+	for rel, expected := range tests {
+		manifest, chunk, ok := splitChunkPath(filepath.FromSlash(rel))
+		if expected == nil {
+			if ok {
+				t.Errorf("%s: expected no chunk, got %s %s", rel, manifest, chunk)
+			}
+			continue
+		}
+
+		if !ok || manifest != expected[0] || chunk != expected[1] {
+			t.Errorf("%s: expected %v, got %s %s", rel, expected, manifest, chunk)
+		}
+	}
+}
+
+func TestChunks(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"src/app.main.modules": "[base]\nlib.js\n[home: base]\nhome.js\n",
+		"src/lib.js":           "#define NAME \"lib\"\nvar lib = NAME;\n",
+		"src/home.js":          "lib.home = 1;\n",
+	})
+	defer os.RemoveAll(dir)
+
+	c := NewConfig(None)
+	chunks, err := compileChunks(context.Background(), c, filepath.Join(dir, "src/app.main.modules"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(chunks) != 2 || string(chunks[0].out) != "var lib = \"lib\";\n" ||
+		string(chunks[1].out) != "lib.home = 1;\n" {
+		t.Fatalf("unexpected chunks: %v", chunks)
+	}
+
+	r, err := http.NewRequest("GET", "/app.home.js", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := FindContent("/", r, http.Dir(filepath.Join(dir, "src")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res == nil || res.srcType != srcOfModules || res.chunk != "home" {
+		t.Fatalf("expected the home chunk, got %+v", res)
+	}
+
+	out := filepath.Join(dir, "out")
+	if _, err := Content(c, http.Dir(filepath.Join(dir, "src"))).Productionize(http.Dir(out)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{"app.base.js": "var lib", "app.home.js": "lib.home"} {
+		data, err := ioutil.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(string(data), expected) {
+			t.Errorf("%s: expected %q, got %q", name, expected, data)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package pork
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// A node in a parsed stylesheet. A node with a nil body is a statement,
+// either a declaration (color: red) or an at-rule without a block
+// (@import "a.css").
+type cssNode struct {
+	prelude string
+	body    []*cssNode
+	block   bool
+}
+
+// Optimization pipe that buffers a stylesheet and minifies it on Close.
+type cssOpt struct {
+	bytes.Buffer
+	w     io.Writer
+	level Optimization
+}
+
+func (o *cssOpt) Close() error {
+	_, err := io.WriteString(o.w, minifyCss(o.String(), o.level))
+	return err
+}
+
+// Reads the next css item, which ends in one of ; { } or the end of
+// input. Comments are removed unless they start with /*!, in which case
+// they are returned as an item terminated by '!'. Strings are left
+// untouched.
+func readCssItem(s string, i int) (string, byte, int) {
+	var b strings.Builder
+	depth := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			e := strings.Index(s[i+2:], "*/")
+			end := len(s)
+			if e >= 0 {
+				end = i + 2 + e + 2
+			}
+			if i+2 < len(s) && s[i+2] == '!' && strings.TrimSpace(b.String()) == "" {
+				// important comments become items of their own
+				return s[i:end], '!', end
+			}
+			b.WriteByte(' ')
+			i = end
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(s) && s[j] != c {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(s) {
+				j++
+			}
+			if j > len(s) {
+				j = len(s)
+			}
+			b.WriteString(s[i:j])
+			i = j
+		case c == '(' || c == '[':
+			depth++
+			b.WriteByte(c)
+			i++
+		case c == ')' || c == ']':
+			depth--
+			b.WriteByte(c)
+			i++
+		case depth <= 0 && (c == ';' || c == '{' || c == '}'):
+			return b.String(), c, i + 1
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), 0, i
+}
+
+// Parses a list of css nodes up to a closing brace or the end of input.
+func parseCss(s string, i int) ([]*cssNode, int) {
+	var nodes []*cssNode
+	for i < len(s) {
+		item, term, n := readCssItem(s, i)
+		i = n
+		item = strings.TrimSpace(item)
+		switch term {
+		case '{':
+			body, n := parseCss(s, i)
+			i = n
+			nodes = append(nodes, &cssNode{prelude: item, body: body, block: true})
+		case '}':
+			if item != "" {
+				nodes = append(nodes, &cssNode{prelude: item})
+			}
+			return nodes, i
+		default:
+			if item != "" {
+				nodes = append(nodes, &cssNode{prelude: item})
+			}
+		}
+	}
+	return nodes, i
+}
+
+func isCssSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// Collapses runs of whitespace (outside of strings) into a single space
+// and removes the whitespace surrounding any of the bytes in tight.
+func collapseCssSpace(s, tight string) string {
+	var b strings.Builder
+	space := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case isCssSpace(c):
+			space = true
+			continue
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(s) && s[j] != c {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				j = len(s) - 1
+			}
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(s[i : j+1])
+			i = j
+		default:
+			if space && b.Len() > 0 && !strings.ContainsRune(tight, rune(c)) {
+				if o := b.String(); !strings.ContainsRune(tight, rune(o[len(o)-1])) {
+					b.WriteByte(' ')
+				}
+			}
+			b.WriteByte(c)
+		}
+		space = false
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+var cssLengthUnits = []string{
+	"px", "em", "rem", "ex", "ch", "vw", "vh", "vmin", "vmax",
+	"cm", "mm", "in", "pt", "pc",
+}
+
+// Shortens a single number (with optional unit) in a css value.
+func shortenCssNumber(num, unit string, inCalc bool) string {
+	neg := strings.HasPrefix(num, "-")
+	if neg || strings.HasPrefix(num, "+") {
+		num = num[1:]
+	}
+
+	if strings.Contains(num, ".") {
+		num = strings.TrimRight(num, "0")
+		num = strings.TrimSuffix(num, ".")
+	}
+	num = strings.TrimLeft(num, "0")
+	if num == "" || num[0] == '.' {
+		if num == "" || num == "." {
+			num = "0"
+		} else {
+			num = "0" + num
+		}
+	}
+
+	if num == "0" {
+		if !inCalc {
+			for _, u := range cssLengthUnits {
+				if strings.EqualFold(unit, u) {
+					return "0"
+				}
+			}
+		}
+		return "0" + unit
+	}
+
+	if strings.HasPrefix(num, "0.") {
+		num = num[1:]
+	}
+
+	if neg {
+		num = "-" + num
+	}
+	return num + unit
+}
+
+// Properties whose zero lengths must keep their unit. In the flex
+// shorthand, a unitless 0 can be read as a flex factor rather than the
+// basis.
+var cssKeepZeroUnits = map[string]bool{
+	"flex":       true,
+	"flex-basis": true,
+}
+
+// Properties whose values are left exactly as they are.
+var cssVerbatimProperties = map[string]bool{
+	"unicode-range": true,
+}
+
+// Finds the end of a url( starting at i, skipping over quoted text.
+// Returns len(v) if the url is not terminated.
+func cssURLEnd(v string, i int) int {
+	for j := i + len("url("); j < len(v); j++ {
+		switch c := v[j]; c {
+		case '\\':
+			j++
+		case '"', '\'':
+			for j++; j < len(v) && v[j] != c; j++ {
+				if v[j] == '\\' {
+					j++
+				}
+			}
+		case ')':
+			return j + 1
+		}
+	}
+	return len(v)
+}
+
+// Shortens colors, numbers and zero lengths in a declaration value. When
+// keepUnits is set, zero lengths keep their unit.
+func shortenCssValue(v string, keepUnits bool) string {
+	var b strings.Builder
+	calc := 0
+	for i := 0; i < len(v); {
+		c := v[i]
+		switch {
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(v) && v[j] != c {
+				if v[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(v) {
+				j++
+			}
+			if j > len(v) {
+				j = len(v)
+			}
+			b.WriteString(v[i:j])
+			i = j
+		case strings.HasPrefix(strings.ToLower(v[i:]), "url("):
+			// leave urls exactly as they are
+			e := cssURLEnd(v, i)
+			b.WriteString(v[i:e])
+			i = e
+		case c == '#':
+			j := i + 1
+			for j < len(v) && isHexDigit(v[j]) {
+				j++
+			}
+			hex := strings.ToLower(v[i+1 : j])
+			if j < len(v) && isIdentPart(v[j]) {
+				// not actually a color
+				hex = v[i+1 : j]
+			} else if len(hex) == 6 && hex[0] == hex[1] && hex[2] == hex[3] && hex[4] == hex[5] {
+				hex = string([]byte{hex[0], hex[2], hex[4]})
+			}
+			b.WriteByte('#')
+			b.WriteString(hex)
+			i = j
+		case isIdentStart(c) || c == '-' && i+1 < len(v) && isIdentStart(v[i+1]):
+			j := i + 1
+			for j < len(v) && (isIdentPart(v[j]) || v[j] == '-') {
+				j++
+			}
+			if j < len(v) && v[j] == '(' && strings.EqualFold(v[i:j], "calc") {
+				calc++
+			}
+			b.WriteString(v[i:j])
+			i = j
+		case c == '(':
+			if calc > 0 {
+				calc++
+			}
+			b.WriteByte(c)
+			i++
+		case c == ')':
+			if calc > 0 {
+				calc--
+			}
+			b.WriteByte(c)
+			i++
+		case (c >= '0' && c <= '9') || c == '.' ||
+			((c == '-' || c == '+') && i+1 < len(v) && (v[i+1] >= '0' && v[i+1] <= '9' || v[i+1] == '.')):
+			j := i + 1
+			for j < len(v) && ((v[j] >= '0' && v[j] <= '9') || v[j] == '.') {
+				j++
+			}
+			k := j
+			for k < len(v) && (isIdentStart(v[k]) || v[k] == '%') {
+				k++
+			}
+			b.WriteString(shortenCssNumber(v[i:j], v[j:k], calc > 0 || keepUnits))
+			i = k
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+func minifyCssDecl(d string) string {
+	if strings.HasPrefix(d, "/*!") {
+		return d
+	}
+
+	if strings.HasPrefix(d, "@") {
+		return collapseCssSpace(d, ",")
+	}
+
+	i := strings.IndexByte(d, ':')
+	if i < 0 {
+		return collapseCssSpace(d, "")
+	}
+
+	prop := strings.TrimSpace(d[:i])
+	val := collapseCssSpace(d[i+1:], ",!")
+	name := strings.ToLower(prop)
+	if !strings.HasPrefix(prop, "--") && !cssVerbatimProperties[name] {
+		val = shortenCssValue(val, cssKeepZeroUnits[name])
+	}
+	return prop + ":" + val
+}
+
+func minifyCssPrelude(p string) string {
+	if strings.HasPrefix(p, "@") {
+		return collapseCssSpace(p, ",:")
+	}
+	return collapseCssSpace(p, ",>+~")
+}
+
+// Minifies the preludes and declarations of the given nodes, dropping
+// anything that ends up empty.
+func minifyCssNodes(nodes []*cssNode) []*cssNode {
+	var res []*cssNode
+	for _, n := range nodes {
+		if !n.block {
+			res = append(res, &cssNode{prelude: minifyCssDecl(n.prelude)})
+			continue
+		}
+
+		body := minifyCssNodes(n.body)
+		if len(body) == 0 && !strings.HasPrefix(n.prelude, "@") {
+			continue
+		}
+		res = append(res, &cssNode{
+			prelude: minifyCssPrelude(n.prelude),
+			body:    body,
+			block:   true,
+		})
+	}
+	return res
+}
+
+func isCssRule(n *cssNode) bool {
+	if !n.block || strings.HasPrefix(n.prelude, "@") {
+		return false
+	}
+	for _, c := range n.body {
+		if c.block {
+			return false
+		}
+	}
+	return true
+}
+
+// Browsers drop an entire rule when they do not understand one of its
+// selectors, so rules using vendor prefixed pseudo classes are never
+// combined with others.
+func hasVendorPseudo(n *cssNode) bool {
+	return strings.Contains(n.prelude, ":-")
+}
+
+func cssBodyString(n *cssNode) string {
+	var b bytes.Buffer
+	writeCssNodes(&b, n.body)
+	return b.String()
+}
+
+// Removes repeated declarations from a rule, keeping the last of each.
+func dedupeCssDecls(body []*cssNode) []*cssNode {
+	seen := map[string]bool{}
+	var res []*cssNode
+	for i := len(body) - 1; i >= 0; i-- {
+		if seen[body[i].prelude] {
+			continue
+		}
+		seen[body[i].prelude] = true
+		res = append([]*cssNode{body[i]}, res...)
+	}
+	return res
+}
+
+// Merges rules in ways that do not change the cascade: exact duplicates
+// are removed in favor of the later rule, adjacent rules with the same
+// selector share a body and adjacent rules with the same body share a
+// selector.
+func mergeCssRules(nodes []*cssNode) []*cssNode {
+	for _, n := range nodes {
+		if n.block {
+			n.body = mergeCssRules(n.body)
+		}
+		if isCssRule(n) {
+			n.body = dedupeCssDecls(n.body)
+		}
+	}
+
+	// exact duplicates, the last one wins.
+	last := map[string]int{}
+	for i, n := range nodes {
+		if isCssRule(n) {
+			last[n.prelude+"{"+cssBodyString(n)] = i
+		}
+	}
+
+	var res []*cssNode
+	for i, n := range nodes {
+		if isCssRule(n) && last[n.prelude+"{"+cssBodyString(n)] != i {
+			continue
+		}
+
+		if len(res) > 0 && isCssRule(n) && isCssRule(res[len(res)-1]) {
+			p := res[len(res)-1]
+			if p.prelude == n.prelude {
+				p.body = dedupeCssDecls(append(p.body, n.body...))
+				continue
+			}
+			if cssBodyString(p) == cssBodyString(n) && !hasVendorPseudo(p) && !hasVendorPseudo(n) {
+				p.prelude = p.prelude + "," + n.prelude
+				continue
+			}
+		}
+		res = append(res, n)
+	}
+	return res
+}
+
+func writeCssNodes(b *bytes.Buffer, nodes []*cssNode) {
+	for i, n := range nodes {
+		b.WriteString(n.prelude)
+		if n.block {
+			b.WriteByte('{')
+			writeCssNodes(b, n.body)
+			b.WriteByte('}')
+		} else if strings.HasPrefix(n.prelude, "/*!") {
+			continue
+		} else if i < len(nodes)-1 || strings.HasPrefix(n.prelude, "@") {
+			b.WriteByte(';')
+		}
+	}
+}
+
+// Minifies a stylesheet. Comments (except /*! ... */) and whitespace are
+// removed and colors and numbers are shortened. At Advanced, duplicate
+// rules are also merged.
+func minifyCss(s string, level Optimization) string {
+	nodes, _ := parseCss(s, 0)
+	nodes = minifyCssNodes(nodes)
+	if level == Advanced {
+		nodes = mergeCssRules(nodes)
+	}
+
+	var b bytes.Buffer
+	writeCssNodes(&b, nodes)
+	return b.String()
+}
@@ -0,0 +1,33 @@
+package pork
+
+import "testing"
+
+func TestMinifyCss(t *testing.T) {
+	tests := []struct {
+		level    Optimization
+		src      string
+		expected string
+	}{
+		{Basic, "body {\n  color: #FF0000;\n}\n", "body{color:#f00}"},
+		{Basic, "/* gone */ a  >  b , c { margin: 0px 0.50em 10.0px; }", "a>b,c{margin:0 .5em 10px}"},
+		{Basic, "a { width: calc(100% - 0px); transition: all 0s; }", "a{width:calc(100% - 0px);transition:all 0s}"},
+		{Basic, "a { background: url( 'x #ffffff.png' ) !important; }", "a{background:url( 'x #ffffff.png' )!important}"},
+		{Basic, "/*! license */\na:hover {}\n", "/*! license */"},
+		{Basic, "@font-face { unicode-range: U+0025-00FF, u+4??; }", "@font-face{unicode-range:U+0025-00FF,u+4??}"},
+		{Basic, "a { background: url(\"a)b.png\") 0px 0px; }", "a{background:url(\"a)b.png\") 0 0}"},
+		{Basic, "a { flex: 1 1 0px; flex-basis: 0.0em; margin: 0px; }", "a{flex:1 1 0px;flex-basis:0em;margin:0}"},
+		{Basic, "@media screen and (max-width : 100px) { a { color: red; } }", "@media screen and (max-width:100px){a{color:red}}"},
+		{Basic, "@import \"a.css\";\na { content: \"  x  \" }", "@import \"a.css\";a{content:\"  x  \"}"},
+		{Basic, "a { color: red } a { color: red }", "a{color:red}a{color:red}"},
+		{Advanced, "a { color: red } b { top: 0 } a { color: red }", "b{top:0}a{color:red}"},
+		{Advanced, "a { color: red } a { top: 0; color: red }", "a{top:0;color:red}"},
+		{Advanced, "a { color: red } b { color: red } ::-moz-selection { color: red }",
+			"a,b{color:red}::-moz-selection{color:red}"},
+	}
+
+	for _, test := range tests {
+		if res := minifyCss(test.src, test.level); res != test.expected {
+			t.Errorf("minify %q: expected %q, got %q", test.src, test.expected, res)
+		}
+	}
+}
@@ -0,0 +1,551 @@
+package pork
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The ways in which a file can refer to other files.
+type depKind int
+
+const (
+	// files that can only use //@include directives
+	depOfPlain depKind = iota
+
+	// JavaScript that is run through the preprocessor
+	depOfJs
+
+	// Sass sources and partials
+	depOfScss
+
+	// .main.modules manifests
+	depOfModules
+
+	// .main.list manifests
+	depOfList
+)
+
+var (
+	sassImportPattern  = regexp.MustCompile(`@(?:import|use|forward)\s+([^;{]+)`)
+	sassStringPattern  = regexp.MustCompile(`"([^"]*)"|'([^']*)'`)
+	sassDataURIPattern = regexp.MustCompile(`datauri\(\s*["']?([^"')]+?)["']?\s*\)`)
+)
+
+// DepGraph is the graph of the files that each of the sources in a set of
+// roots is built from.
+type DepGraph struct {
+	// The sources that are built by Productionize.
+	Entries []string
+
+	// The files that each file refers to directly.
+	Deps map[string][]string
+
+	// The references in each file that could not be resolved.
+	Missing map[string][]string
+}
+
+// Deps scans the sources beneath roots and follows their //@include
+// directives, #include directives, ES module imports, Sass imports and
+// datauri() references.
+func Deps(c *Config, roots ...http.Dir) (*DepGraph, error) {
+	g := &DepGraph{
+		Deps:    map[string][]string{},
+		Missing: map[string][]string{},
+	}
+
+	for _, root := range roots {
+		src := string(root)
+		if err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() || typeOfSrc(path) == srcOfUnknown {
+				return nil
+			}
+
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+
+			g.Entries = append(g.Entries, abs)
+			return g.scan(c, abs, depKindOf(abs))
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(g.Entries)
+	return g, nil
+}
+
+func depKindOf(path string) depKind {
+	switch typeOfSrc(path) {
+	case srcOfJs:
+		return depOfJs
+	case srcOfScss:
+		return depOfScss
+	case srcOfModules:
+		return depOfModules
+	case srcOfList:
+		return depOfList
+	}
+
+	switch filepath.Ext(path) {
+	case ".scss", ".sass":
+		return depOfScss
+	}
+	return depOfPlain
+}
+
+// Records the dependencies of filename and of everything it refers to.
+func (g *DepGraph) scan(c *Config, filename string, kind depKind) error {
+	if _, ok := g.Deps[filename]; ok {
+		return nil
+	}
+
+	includes, deps, missing, err := findDeps(c, filename, kind)
+	if err != nil {
+		return err
+	}
+
+	g.Deps[filename] = append(includes, deps...)
+	if len(missing) > 0 {
+		g.Missing[filename] = missing
+	}
+
+	// files included with //@include are copied verbatim
+	for _, path := range includes {
+		if err := g.scan(c, path, depOfPlain); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range deps {
+		k := kind
+		switch kind {
+		case depOfScss:
+			k = depKindOf(path)
+		case depOfModules:
+			k = depOfJs
+		case depOfList:
+			// the files in a list are copied as they are
+			k = depOfPlain
+		}
+
+		if err := g.scan(c, path, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finds the files that filename includes with //@include directives,
+// the files it refers to in its own language and any references that
+// cannot be resolved.
+func findDeps(c *Config, filename string, kind depKind) ([]string, []string, []string, error) {
+	var includes, missing []string
+	if typeOfSrc(filename) != srcOfUnknown {
+		paths, err := directiveIncludes(filename)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %s", filename, err)
+		}
+
+		for _, path := range paths {
+			if _, err := os.Stat(path); err != nil {
+				missing = append(missing, path)
+				continue
+			}
+			includes = append(includes, path)
+		}
+	}
+
+	var deps []string
+	var err error
+	switch kind {
+	case depOfJs:
+		deps, missing, err = jsIncludeDeps(c, filename, missing)
+		if err == nil {
+			var imports []string
+			imports, missing, err = jsImportDeps(c, filename, missing)
+			deps = append(deps, imports...)
+		}
+	case depOfScss:
+		deps, missing, err = sassDeps(c, filename, missing)
+	case depOfModules:
+		deps, missing, err = chunkDeps(filename, missing)
+	case depOfList:
+		deps, missing, err = listDeps(filename, missing)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if includes, err = absPaths(includes); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if deps, err = absPaths(deps); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return includes, deps, missing, nil
+}
+
+func absPaths(paths []string) ([]string, error) {
+	var res []string
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, abs)
+	}
+	return res, nil
+}
+
+// Finds the files named by #include directives. Conditionals are not
+// evaluated, so every include in the file is reported.
+func jsIncludeDeps(c *Config, filename string, missing []string) ([]string, []string, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := newPreprocessor(c.JsIncludes)
+	dir := filepath.Dir(filename)
+
+	var deps []string
+	for _, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, arg := splitDirective(line[1:])
+		if name != "include" {
+			continue
+		}
+
+		arg = stripDirectiveComment(arg)
+		target, err := p.resolveInclude(dir, arg)
+		if err != nil {
+			missing = append(missing, arg)
+			continue
+		}
+		deps = append(deps, target)
+	}
+	return deps, missing, nil
+}
+
+// Finds the files listed in a .main.modules manifest.
+func chunkDeps(filename string, missing []string) ([]string, []string, error) {
+	chunks, err := readChunks(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var deps []string
+	for _, ch := range chunks {
+		for _, file := range ch.files {
+			if _, err := os.Stat(file); err != nil {
+				missing = append(missing, file)
+				continue
+			}
+			deps = append(deps, file)
+		}
+	}
+	return deps, missing, nil
+}
+
+// Removes comments from Sass source while leaving strings and url()
+// arguments alone.
+func stripSassComments(src string) string {
+	var b strings.Builder
+	for i := 0; i < len(src); {
+		switch c := src[i]; {
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c && src[j] != '\n' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(src) {
+				j++
+			}
+			if j > len(src) {
+				j = len(src)
+			}
+			b.WriteString(src[i:j])
+			i = j
+		case strings.HasPrefix(src[i:], "url("):
+			j := strings.IndexByte(src[i:], ')')
+			if j < 0 {
+				j = len(src) - i - 1
+			}
+			b.WriteString(src[i : i+j+1])
+			i += j + 1
+		case strings.HasPrefix(src[i:], "/*"):
+			j := strings.Index(src[i+2:], "*/")
+			if j < 0 {
+				return b.String()
+			}
+			b.WriteByte(' ')
+			i += j + 4
+		case strings.HasPrefix(src[i:], "//"):
+			j := strings.IndexByte(src[i:], '\n')
+			if j < 0 {
+				return b.String()
+			}
+			i += j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// Whether an @import is left for the browser rather than being
+// imported by Sass.
+func isPlainCssImport(name string) bool {
+	return strings.HasSuffix(name, ".css") ||
+		strings.HasPrefix(name, "http://") ||
+		strings.HasPrefix(name, "https://") ||
+		strings.HasPrefix(name, "//") ||
+		strings.HasPrefix(name, "sass:")
+}
+
+// Finds the file that Sass loads for an import of name from dir, trying
+// partials, the various extensions and index files.
+func resolveSassImport(dir, name string) string {
+	base, file := filepath.Split(filepath.FromSlash(name))
+	base = filepath.Join(dir, base)
+
+	var candidates []string
+	switch filepath.Ext(file) {
+	case ".scss", ".sass":
+		candidates = []string{file, "_" + file}
+	default:
+		for _, ext := range []string{".scss", ".sass", ".css"} {
+			candidates = append(candidates, file+ext, "_"+file+ext)
+		}
+		for _, index := range []string{"_index.scss", "index.scss", "_index.sass", "index.sass"} {
+			candidates = append(candidates, filepath.Join(file, index))
+		}
+	}
+
+	for _, candidate := range candidates {
+		path := filepath.Join(base, candidate)
+		if s, err := os.Stat(path); err == nil && !s.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// Finds the files that a Sass source imports or embeds with datauri().
+// Both are resolved against the directory of the file and then against
+// the ScssIncludes.
+func sassDeps(c *Config, filename string, missing []string) ([]string, []string, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dirs := append([]string{filepath.Dir(filename)}, c.ScssIncludes...)
+	text := stripSassComments(string(src))
+
+	var deps []string
+	for _, m := range sassImportPattern.FindAllStringSubmatch(text, -1) {
+		for _, s := range sassStringPattern.FindAllStringSubmatch(m[1], -1) {
+			name := s[1] + s[2]
+			if isPlainCssImport(name) {
+				continue
+			}
+
+			path := ""
+			for _, dir := range dirs {
+				if path = resolveSassImport(dir, name); path != "" {
+					break
+				}
+			}
+
+			if path == "" {
+				missing = append(missing, name)
+				continue
+			}
+			deps = append(deps, path)
+		}
+	}
+
+	for _, m := range sassDataURIPattern.FindAllStringSubmatch(text, -1) {
+		name := strings.TrimSpace(m[1])
+		if isComputedDataURI(name) {
+			continue
+		}
+
+		path := resolveDataURI(c, filepath.Dir(filename), name)
+		if path == "" {
+			missing = append(missing, name)
+			continue
+		}
+		deps = append(deps, path)
+	}
+
+	return deps, missing, nil
+}
+
+// Reverse returns the entries that have to be rebuilt when filename
+// changes.
+func (g *DepGraph) Reverse(filename string) []string {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil
+	}
+
+	var res []string
+	for _, entry := range g.Entries {
+		if g.reaches(entry, abs, map[string]bool{}) {
+			res = append(res, entry)
+		}
+	}
+	return res
+}
+
+// Whether target is from, or is one of its dependencies.
+func (g *DepGraph) reaches(from, target string, seen map[string]bool) bool {
+	if from == target {
+		return true
+	}
+
+	if seen[from] {
+		return false
+	}
+	seen[from] = true
+
+	for _, dep := range g.Deps[from] {
+		if g.reaches(dep, target, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// DisplayPath shortens an absolute path to a path relative to the working
+// directory when it is beneath it.
+func DisplayPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
+}
+
+// WriteText writes each entry followed by the tree of the files it
+// depends on.
+func (g *DepGraph) WriteText(w io.Writer) error {
+	var b strings.Builder
+	var walk func(path, indent string, stack map[string]bool)
+	walk = func(path, indent string, stack map[string]bool) {
+		for _, dep := range g.Deps[path] {
+			if stack[dep] {
+				fmt.Fprintf(&b, "%s%s (cycle)\n", indent, DisplayPath(dep))
+				continue
+			}
+			fmt.Fprintf(&b, "%s%s\n", indent, DisplayPath(dep))
+			stack[dep] = true
+			walk(dep, indent+"  ", stack)
+			delete(stack, dep)
+		}
+		for _, name := range g.Missing[path] {
+			fmt.Fprintf(&b, "%s%s (not found)\n", indent, name)
+		}
+	}
+
+	for _, entry := range g.Entries {
+		fmt.Fprintln(&b, DisplayPath(entry))
+		walk(entry, "  ", map[string]bool{entry: true})
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the graph as a JSON object.
+func (g *DepGraph) WriteJSON(w io.Writer) error {
+	deps := map[string][]string{}
+	for path, ds := range g.Deps {
+		d := []string{}
+		for _, dep := range ds {
+			d = append(d, DisplayPath(dep))
+		}
+		deps[DisplayPath(path)] = d
+	}
+
+	missing := map[string][]string{}
+	for path, names := range g.Missing {
+		missing[DisplayPath(path)] = names
+	}
+
+	entries := []string{}
+	for _, entry := range g.Entries {
+		entries = append(entries, DisplayPath(entry))
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Entries []string            `json:"entries"`
+		Deps    map[string][]string `json:"deps"`
+		Missing map[string][]string `json:"missing,omitempty"`
+	}{entries, deps, missing}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// WriteDot writes the graph in the Graphviz dot language with an edge
+// from each file to each of the files it refers to.
+func (g *DepGraph) WriteDot(w io.Writer) error {
+	var paths []string
+	for path := range g.Deps {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	fmt.Fprintln(&b, "digraph deps {")
+	for _, entry := range g.Entries {
+		fmt.Fprintf(&b, "  %q [shape=box];\n", DisplayPath(entry))
+	}
+	for _, path := range paths {
+		for _, dep := range g.Deps[path] {
+			fmt.Fprintf(&b, "  %q -> %q;\n", DisplayPath(path), DisplayPath(dep))
+		}
+		for _, name := range g.Missing[path] {
+			fmt.Fprintf(&b, "  %q -> %q [style=dashed];\n", DisplayPath(path), name)
+		}
+	}
+	fmt.Fprintln(&b, "}")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
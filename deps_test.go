@@ -0,0 +1,80 @@
+package pork
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDeps(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"css/app.main.scss":   "@import \"base\", \"http://x.com/a.css\";\n// @import \"commented\";\n.a { b: datauri(\"../img/a.png\"); }\n",
+		"css/other.main.scss": "@import 'colors';\n@import 'nope';\n",
+		"css/_base.scss":      "/* @import 'hidden'; */\n@import \"colors\";\n",
+		"css/_colors.scss":    "$red: #f00;\n",
+		"img/a.png":           "",
+		"app.main.js":         "//@include(\"header.txt\")\n#include \"util.js\"\nvar a;\n",
+		"header.txt":          "#include \"ignored.js\"\n",
+		"util.js":             "#include <lib.js>\n",
+		"inc/lib.js":          "var lib;\n",
+		"mod.main.js":         "import {x} from \"./mod/x\";\nimport \"nope\";\n",
+		"mod/x.js":            "export var x;\n",
+		"vendor.main.list":    "vendor/*.js\nnope/*.js\n",
+		"vendor/v.js":         "var v;\n",
+	})
+	defer os.RemoveAll(dir)
+
+	path := func(name string) string {
+		return filepath.Join(dir, filepath.FromSlash(name))
+	}
+
+	c := NewConfig(None)
+	c.JsIncludes = []string{path("inc")}
+
+	g, err := Deps(c, http.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deps := map[string][]string{
+		"app.main.js":         {path("header.txt"), path("util.js")},
+		"header.txt":          nil,
+		"util.js":             {path("inc/lib.js")},
+		"css/app.main.scss":   {path("css/_base.scss"), path("img/a.png")},
+		"css/_base.scss":      {path("css/_colors.scss")},
+		"css/other.main.scss": {path("css/_colors.scss")},
+		"mod.main.js":         {path("mod/x.js")},
+		"vendor.main.list":    {path("vendor/v.js")},
+	}
+	for name, expected := range deps {
+		if actual := g.Deps[path(name)]; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("deps of %s: expected %v, got %v", name, expected, actual)
+		}
+	}
+
+	if missing := g.Missing[path("css/other.main.scss")]; !reflect.DeepEqual(missing, []string{"nope"}) {
+		t.Errorf("expected nope to be missing, got %v", missing)
+	}
+
+	if missing := g.Missing[path("mod.main.js")]; !reflect.DeepEqual(missing, []string{"nope"}) {
+		t.Errorf("expected nope to be missing, got %v", missing)
+	}
+
+	if missing := g.Missing[path("vendor.main.list")]; !reflect.DeepEqual(missing, []string{"nope/*.js"}) {
+		t.Errorf("expected nope/*.js to be missing, got %v", missing)
+	}
+
+	reverse := map[string][]string{
+		"css/_colors.scss": {path("css/app.main.scss"), path("css/other.main.scss")},
+		"img/a.png":        {path("css/app.main.scss")},
+		"inc/lib.js":       {path("app.main.js")},
+		"header.txt":       {path("app.main.js")},
+	}
+	for name, expected := range reverse {
+		if actual := g.Reverse(path(name)); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("reverse of %s: expected %v, got %v", name, expected, actual)
+		}
+	}
+}
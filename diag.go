@@ -0,0 +1,369 @@
+package pork
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Severity ...
+type Severity int
+
+const (
+	// SeverityError ...
+	SeverityError Severity = iota
+
+	// SeverityWarning ...
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Diagnostic is a single error or warning reported by a compiler. Line
+// and Column are 1-based and are zero when the compiler did not report
+// them.
+type Diagnostic struct {
+	File     string
+	Line     int
+	Column   int
+	Severity Severity
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	var b strings.Builder
+	if d.File != "" {
+		b.WriteString(d.File)
+		if d.Line > 0 {
+			fmt.Fprintf(&b, ":%d", d.Line)
+			if d.Column > 0 {
+				fmt.Fprintf(&b, ":%d", d.Column)
+			}
+		}
+		b.WriteString(": ")
+	}
+	fmt.Fprintf(&b, "%s: %s", d.Severity, d.Message)
+	return b.String()
+}
+
+// CompileError is returned when an external compiler fails. It carries the
+// diagnostics parsed from the compiler's output.
+type CompileError struct {
+	// The name of the tool that failed (sass, tsc, jsx, closure-compiler).
+	Tool string
+
+	// The source that was being compiled.
+	Src string
+
+	Diagnostics []Diagnostic
+
+	// Everything the tool wrote to stdout and stderr.
+	Output string
+
+	Err error
+}
+
+func (e *CompileError) Error() string {
+	var lines []string
+	for _, d := range e.Diagnostics {
+		if d.Severity == SeverityError {
+			lines = append(lines, fmt.Sprintf("%s: %s", e.Tool, d))
+		}
+	}
+
+	// the warnings are what failed when warnings are errors
+	if len(lines) == 0 && errors.Is(e.Err, ErrWarnings) {
+		for _, d := range e.Diagnostics {
+			lines = append(lines, fmt.Sprintf("%s: %s", e.Tool, d))
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s: %s", e.Tool, e.Src, e.Err))
+	}
+
+	if len(lines) == 0 {
+		return fmt.Sprintf("%s: %s: %s", e.Tool, e.Src, e.Err)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// Parses the output of a tool into diagnostics.
+type diagParser func(out string) []Diagnostic
+
+// Returns only the warnings among diags.
+func warningsOf(diags []Diagnostic) []Diagnostic {
+	var warnings []Diagnostic
+	for _, d := range diags {
+		if d.Severity == SeverityWarning {
+			warnings = append(warnings, d)
+		}
+	}
+	return warnings
+}
+
+// Runs an external compiler with its output captured. A failure becomes a
+// CompileError holding the diagnostics found by parse. On success, the
+// warnings that parse finds in any output are passed to the Config's
+// WarningLogger (or the output to stderr when there is none). When the
+// command's stdout is already connected, only stderr is captured.
+func runCompiler(ctx context.Context, c *Config, tool, src string, cm *exec.Cmd,
+	parse diagParser) error {
+	var out bytes.Buffer
+	if cm.Stdout == nil {
+		cm.Stdout = &out
+	}
+	cm.Stderr = &out
+
+	if err := cm.Run(); err != nil {
+		if ctx.Err() != nil {
+			return contextError(ctx, tool)
+		}
+
+		// the tool could not be started at all
+		if _, ok := err.(*exec.ExitError); !ok {
+			return fmt.Errorf("%s: %s", tool, err)
+		}
+		return newCompileError(tool, src, out.String(), err, parse)
+	}
+
+	if out.Len() > 0 {
+		logWarnings(c, src, out.String(), warningsOf(parse(out.String())))
+	}
+	return nil
+}
+
+func newCompileError(tool, src, out string, err error, parse diagParser) *CompileError {
+	diags := parse(out)
+
+	hasError := false
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			hasError = true
+			break
+		}
+	}
+
+	// the output could not be understood, so report it as it is
+	if !hasError {
+		msg := firstLine(out)
+		if msg == "" {
+			msg = err.Error()
+		}
+		diags = append(diags, Diagnostic{
+			File:     src,
+			Severity: SeverityError,
+			Message:  msg,
+		})
+	}
+
+	return &CompileError{
+		Tool:        tool,
+		Src:         src,
+		Diagnostics: diags,
+		Output:      out,
+		Err:         err,
+	}
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+var (
+	// Ruby sass with --trace: a.scss:3: message (Sass::SyntaxError)
+	rubySassTracePattern = regexp.MustCompile(`^(.+?):(\d+): (.*?) \(Sass::SyntaxError\)$`)
+
+	// Ruby sass: Error: message / WARNING on line 3 of a.scss: message
+	rubySassHeadPattern = regexp.MustCompile(`^(Error|WARNING|DEPRECATION WARNING)(?: on line (\d+)(?::(\d+))?(?: of (.+?))?)?: (.*)$`)
+	rubySassLinePattern = regexp.MustCompile(`^\s+on line (\d+)(?::(\d+))? of (.+?)(?:, in .*)?$`)
+
+	// Dart sass: Error: message, followed by a snippet and a trace of
+	// locations like "  a.scss 3:4  root stylesheet".
+	dartSassHeadPattern = regexp.MustCompile(`^(Error|Warning|Deprecation Warning)(?: \[[^\]]*\])?: (.*)$`)
+	dartSassLinePattern = regexp.MustCompile(`^\s+(\S.*?) (\d+):(\d+)\s`)
+)
+
+func sassSeverity(s string) Severity {
+	if s == "Error" {
+		return SeverityError
+	}
+	return SeverityWarning
+}
+
+// Parses the output of both Ruby sass and Dart sass.
+func parseSassDiagnostics(out string) []Diagnostic {
+	var diags []Diagnostic
+	var cur *Diagnostic
+
+	// a diagnostic whose location is still to come
+	pending := func(d Diagnostic) {
+		diags = append(diags, d)
+		cur = &diags[len(diags)-1]
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if m := rubySassTracePattern.FindStringSubmatch(line); m != nil {
+			cur = nil
+			diags = append(diags, Diagnostic{
+				File:     m[1],
+				Line:     atoi(m[2]),
+				Severity: SeverityError,
+				Message:  m[3],
+			})
+			continue
+		}
+
+		if m := rubySassHeadPattern.FindStringSubmatch(line); m != nil && m[2] != "" {
+			cur = nil
+			diags = append(diags, Diagnostic{
+				File:     m[4],
+				Line:     atoi(m[2]),
+				Column:   atoi(m[3]),
+				Severity: sassSeverity(m[1]),
+				Message:  m[5],
+			})
+			continue
+		}
+
+		if m := dartSassHeadPattern.FindStringSubmatch(line); m != nil {
+			pending(Diagnostic{Severity: sassSeverity(m[1]), Message: m[2]})
+			continue
+		}
+
+		if m := rubySassHeadPattern.FindStringSubmatch(line); m != nil {
+			pending(Diagnostic{Severity: sassSeverity(m[1]), Message: m[5]})
+			continue
+		}
+
+		if cur == nil || cur.Line > 0 {
+			continue
+		}
+
+		if m := rubySassLinePattern.FindStringSubmatch(line); m != nil {
+			cur.File, cur.Line, cur.Column = m[3], atoi(m[1]), atoi(m[2])
+		} else if m := dartSassLinePattern.FindStringSubmatch(line); m != nil && !strings.ContainsAny(m[1], "│╷╵|") {
+			cur.File, cur.Line, cur.Column = m[1], atoi(m[2]), atoi(m[3])
+		} else if cur.File == "" && strings.HasPrefix(line, "  ") && !strings.ContainsAny(line, "│╷╵|") {
+			// Ruby sass continues long messages on indented lines
+			if s := strings.TrimSpace(line); s != "" && !strings.HasPrefix(s, "Use --trace") {
+				cur.Message += " " + s
+			}
+		}
+	}
+	return diags
+}
+
+var (
+	// a.ts(3,5): error TS2322: message
+	tscPattern = regexp.MustCompile(`^(.+?)\((\d+),(\d+)\): (error|warning) (TS\d+: .*)$`)
+
+	// a.ts:3:5 - error TS2322: message
+	tscPrettyPattern = regexp.MustCompile(`^(.+?):(\d+):(\d+) - (error|warning) (TS\d+: .*)$`)
+
+	// error TS5023: message
+	tscGlobalPattern = regexp.MustCompile(`^(error|warning) (TS\d+: .*)$`)
+)
+
+func parseTscDiagnostics(out string) []Diagnostic {
+	var diags []Diagnostic
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+
+		m := tscPattern.FindStringSubmatch(line)
+		if m == nil {
+			m = tscPrettyPattern.FindStringSubmatch(line)
+		}
+
+		if m != nil {
+			sev := SeverityError
+			if m[4] == "warning" {
+				sev = SeverityWarning
+			}
+			diags = append(diags, Diagnostic{
+				File:     m[1],
+				Line:     atoi(m[2]),
+				Column:   atoi(m[3]),
+				Severity: sev,
+				Message:  m[5],
+			})
+		} else if m := tscGlobalPattern.FindStringSubmatch(line); m != nil {
+			sev := SeverityError
+			if m[1] == "warning" {
+				sev = SeverityWarning
+			}
+			diags = append(diags, Diagnostic{Severity: sev, Message: m[2]})
+		}
+	}
+	return diags
+}
+
+// [a.jsx:3:5] message
+var jsxPattern = regexp.MustCompile(`^\[(.+?):(\d+)(?::(\d+))?\] (.*)$`)
+
+func parseJsxDiagnostics(out string) []Diagnostic {
+	var diags []Diagnostic
+	for _, line := range strings.Split(out, "\n") {
+		m := jsxPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+
+		d := Diagnostic{
+			File:     m[1],
+			Line:     atoi(m[2]),
+			Column:   atoi(m[3]),
+			Severity: SeverityError,
+			Message:  m[4],
+		}
+
+		if lower := strings.ToLower(d.Message); strings.HasPrefix(lower, "warning:") {
+			d.Severity = SeverityWarning
+			d.Message = strings.TrimSpace(d.Message[len("warning:"):])
+		}
+
+		diags = append(diags, d)
+	}
+	return diags
+}
+
+// stdin:3:5: ERROR - [JSC_TYPE_MISMATCH] message
+var closurePattern = regexp.MustCompile(`^(.+?):(\d+)(?::(\d+))?: (ERROR|WARNING) - (.*)$`)
+
+func parseClosureDiagnostics(out string) []Diagnostic {
+	var diags []Diagnostic
+	for _, line := range strings.Split(out, "\n") {
+		m := closurePattern.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+
+		sev := SeverityError
+		if m[4] == "WARNING" {
+			sev = SeverityWarning
+		}
+
+		diags = append(diags, Diagnostic{
+			File:     m[1],
+			Line:     atoi(m[2]),
+			Column:   atoi(m[3]),
+			Severity: sev,
+			Message:  m[5],
+		})
+	}
+	return diags
+}
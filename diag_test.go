@@ -0,0 +1,138 @@
+package pork
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	tests := []struct {
+		name     string
+		parse    diagParser
+		out      string
+		expected []Diagnostic
+	}{
+		{"ruby sass", parseSassDiagnostics,
+			"Error: Invalid CSS after \"a {\": expected \"}\", was \"\"\n" +
+				"        on line 3 of /src/a.scss\n" +
+				"  Use --trace for backtrace.\n",
+			[]Diagnostic{{"/src/a.scss", 3, 0, SeverityError, "Invalid CSS after \"a {\": expected \"}\", was \"\""}}},
+		{"ruby sass trace", parseSassDiagnostics,
+			"/src/_b.scss:7: Undefined variable: \"$x\". (Sass::SyntaxError)\n" +
+				"\tfrom /usr/lib/ruby/sass/script/tree/variable.rb:49:in `_perform'\n",
+			[]Diagnostic{{"/src/_b.scss", 7, 0, SeverityError, "Undefined variable: \"$x\"."}}},
+		{"ruby sass warning", parseSassDiagnostics,
+			"WARNING on line 2 of /src/a.scss: this is deprecated\n",
+			[]Diagnostic{{"/src/a.scss", 2, 0, SeverityWarning, "this is deprecated"}}},
+		{"dart sass", parseSassDiagnostics,
+			"Deprecation Warning [import]: Sass @import rules are deprecated.\n" +
+				"  ╷\n" +
+				"1 │ @import \"b\";\n" +
+				"  │         ^^^\n" +
+				"  ╵\n" +
+				"    a.scss 1:9  root stylesheet\n" +
+				"\n" +
+				"Error: expected \"}\".\n" +
+				"  ╷\n" +
+				"3 │ a {\n" +
+				"  │    ^\n" +
+				"  ╵\n" +
+				"  src/_b.scss 3:4  @import\n" +
+				"  a.scss 1:9       root stylesheet\n",
+			[]Diagnostic{
+				{"a.scss", 1, 9, SeverityWarning, "Sass @import rules are deprecated."},
+				{"src/_b.scss", 3, 4, SeverityError, "expected \"}\"."},
+			}},
+		{"tsc", parseTscDiagnostics,
+			"a.ts(3,5): error TS2322: Type 'string' is not assignable to type 'number'.\n" +
+				"b.ts:10:1 - error TS1005: ';' expected.\n" +
+				"error TS5023: Unknown compiler option 'x'.\n",
+			[]Diagnostic{
+				{"a.ts", 3, 5, SeverityError, "TS2322: Type 'string' is not assignable to type 'number'."},
+				{"b.ts", 10, 1, SeverityError, "TS1005: ';' expected."},
+				{"", 0, 0, SeverityError, "TS5023: Unknown compiler option 'x'."},
+			}},
+		{"jsx", parseJsxDiagnostics,
+			"[a.jsx:4:10] expected ';' but got 'x'\n" +
+				"  var a = 1 x;\n" +
+				"            ^\n" +
+				"[a.jsx:9] warning: unused variable\n",
+			[]Diagnostic{
+				{"a.jsx", 4, 10, SeverityError, "expected ';' but got 'x'"},
+				{"a.jsx", 9, 0, SeverityWarning, "unused variable"},
+			}},
+		{"closure", parseClosureDiagnostics,
+			"stdin:3: WARNING - [JSC_SUSPICIOUS_SEMICOLON] If this if/for/while really shouldn't have a body, use {}\n" +
+				"stdin:12:4: ERROR - [JSC_PARSE_ERROR] Parse error. missing ; before statement\n" +
+				"1 error(s), 1 warning(s)\n",
+			[]Diagnostic{
+				{"stdin", 3, 0, SeverityWarning, "[JSC_SUSPICIOUS_SEMICOLON] If this if/for/while really shouldn't have a body, use {}"},
+				{"stdin", 12, 4, SeverityError, "[JSC_PARSE_ERROR] Parse error. missing ; before statement"},
+			}},
+	}
+
+	for _, test := range tests {
+		if diags := test.parse(test.out); !reflect.DeepEqual(diags, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, diags)
+		}
+	}
+}
+
+func TestCompileErrorAs(t *testing.T) {
+	cause := errors.New("exit status 1")
+	err := fmt.Errorf("wrapped: %w",
+		newCompileError("tsc", "a.ts", "a.ts(1,2): error TS1005: ';' expected.\n", cause, parseTscDiagnostics))
+
+	var ce *CompileError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected a CompileError, got %v", err)
+	}
+
+	if len(ce.Diagnostics) != 1 || ce.Diagnostics[0].Line != 1 {
+		t.Fatalf("unexpected diagnostics: %v", ce.Diagnostics)
+	}
+
+	if !errors.Is(err, cause) {
+		t.Fatalf("expected the error to wrap the cause")
+	}
+
+	// output that cannot be parsed still produces a diagnostic
+	ce = newCompileError("sass", "a.scss", "something broke\n", cause, parseSassDiagnostics)
+	if len(ce.Diagnostics) != 1 || ce.Diagnostics[0].Message != "something broke" {
+		t.Fatalf("unexpected diagnostics: %v", ce.Diagnostics)
+	}
+}
+
+// The warnings of a compiler that succeeds go to the WarningLogger rather
+// than stderr, and stdout that is connected is left alone.
+func TestRunCompilerWarnings(t *testing.T) {
+	var logged []Diagnostic
+	c := NewConfig(None)
+	c.WarningLogger = func(src string, warnings []Diagnostic) {
+		if src != "a.scss" {
+			t.Errorf("expected warnings for a.scss, got %s", src)
+		}
+		logged = append(logged, warnings...)
+	}
+
+	var out bytes.Buffer
+	cm := exec.Command("sh", "-c", "echo 'a {}'; echo 'WARNING on line 2 of /src/a.scss: this is deprecated' >&2")
+	cm.Stdout = &out
+	if err := runCompiler(context.Background(), c, "sass", "a.scss", cm, parseSassDiagnostics); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "a {}\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	expected := []Diagnostic{{"/src/a.scss", 2, 0, SeverityWarning, "this is deprecated"}}
+	if !reflect.DeepEqual(logged, expected) {
+		t.Fatalf("expected %v, got %v", expected, logged)
+	}
+}
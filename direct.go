@@ -14,23 +14,32 @@ import (
   "strings"
 )
 
-// Execute an include directive
-func execInclude(base, dir string, args []ast.Expr, w io.Writer) error {
+// Extracts the file names given to an include directive.
+func includeArgs(dir string, args []ast.Expr) ([]string, error) {
   strs := make([]string, len(args))
   for i, arg := range args {
 
     bl, ok := arg.(*ast.BasicLit)
     if !ok || bl.Kind != token.STRING {
-      return fmt.Errorf("expected string literal: %s", dir[arg.Pos()-1:arg.End()-1])
+      return nil, fmt.Errorf("expected string literal: %s", dir[arg.Pos()-1:arg.End()-1])
     }
 
     sv, err := strconv.Unquote(bl.Value)
     if err != nil {
-      return err
+      return nil, err
     }
 
     strs[i] = sv
   }
+  return strs, nil
+}
+
+// Execute an include directive
+func execInclude(base, dir string, args []ast.Expr, w io.Writer) error {
+  strs, err := includeArgs(dir, args)
+  if err != nil {
+    return err
+  }
 
   for _, str := range strs {
     if err := catFile(w, filepath.Join(base, str)); err != nil {
@@ -41,19 +50,28 @@ func execInclude(base, dir string, args []ast.Expr, w io.Writer) error {
   return nil
 }
 
-// Expand an individual directive into the given writer.
-func expandDirective(base, dir string, w io.Writer) error {
+// Parses a directive into its name and call expression.
+func parseDirective(dir string) (string, *ast.CallExpr, error) {
   e, err := parser.ParseExpr(dir)
   if err != nil {
-    return err
+    return "", nil, err
   }
 
   c, ok := e.(*ast.CallExpr)
   if !ok {
-    return fmt.Errorf("expected expression: %s", dir)
+    return "", nil, fmt.Errorf("expected expression: %s", dir)
+  }
+
+  return dir[c.Fun.Pos()-1 : c.Fun.End()-1], c, nil
+}
+
+// Expand an individual directive into the given writer.
+func expandDirective(base, dir string, w io.Writer) error {
+  name, c, err := parseDirective(dir)
+  if err != nil {
+    return err
   }
 
-  name := dir[c.Fun.Pos()-1 : c.Fun.End()-1]
   switch name {
   case "include":
     return execInclude(base, dir, c.Args, w)
@@ -62,8 +80,9 @@ func expandDirective(base, dir string, w io.Writer) error {
   }
 }
 
-// Expand all source directives into the given writer
-func expandDirectives(filename string, w io.Writer) error {
+// Calls fn with each of the source directives in the comments at the top
+// of filename.
+func eachDirective(filename string, fn func(base, dir string) error) error {
   r, err := os.Open(filename)
   if err != nil {
     return err
@@ -95,9 +114,43 @@ func expandDirectives(filename string, w io.Writer) error {
     }
 
     if strings.HasPrefix(l, "//@") {
-      if err := expandDirective(base, strings.TrimSpace(l[3:]), w); err != nil {
+      if err := fn(base, strings.TrimSpace(l[3:])); err != nil {
         return err
       }
     }
   }
 }
+
+// Expand all source directives into the given writer
+func expandDirectives(filename string, w io.Writer) error {
+  return eachDirective(filename, func(base, dir string) error {
+    return expandDirective(base, dir, w)
+  })
+}
+
+// Returns the paths of the files included by the source directives in
+// filename.
+func directiveIncludes(filename string) ([]string, error) {
+  var paths []string
+  err := eachDirective(filename, func(base, dir string) error {
+    name, c, err := parseDirective(dir)
+    if err != nil {
+      return err
+    }
+
+    if name != "include" {
+      return nil
+    }
+
+    strs, err := includeArgs(dir, c.Args)
+    if err != nil {
+      return err
+    }
+
+    for _, str := range strs {
+      paths = append(paths, filepath.Join(base, str))
+    }
+    return nil
+  })
+  return paths, err
+}
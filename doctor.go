@@ -0,0 +1,236 @@
+package pork
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// How long a single tool is given to report its version.
+const toolCheckTimeout = 30 * time.Second
+
+// ToolStatus describes the state of one external tool that pork uses.
+type ToolStatus struct {
+	Name    string
+	Path    string
+	Found   string
+	Version string
+	Err     error
+
+	// The source types (or features) that are unavailable when this tool
+	// does not work.
+	Disables []string
+
+	// When a fallback exists, a description of what happens instead.
+	Fallback string
+}
+
+// OK returns true if the tool was found and responded as expected.
+func (s *ToolStatus) OK() bool {
+	return s.Err == nil
+}
+
+// Toolchain is the result of checking all of the external tools.
+type Toolchain struct {
+	Tools []*ToolStatus
+}
+
+// OK returns true if every tool in the toolchain works.
+func (t *Toolchain) OK() bool {
+	for _, tool := range t.Tools {
+		if !tool.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Disabled returns the source types and features that cannot be used
+// with this toolchain.
+func (t *Toolchain) Disabled() []string {
+	var res []string
+	for _, tool := range t.Tools {
+		if !tool.OK() && tool.Fallback == "" {
+			res = append(res, tool.Disables...)
+		}
+	}
+	return res
+}
+
+// WriteTo writes a human readable report of the toolchain.
+func (t *Toolchain) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TOOL\tSTATUS\tVERSION\tPATH")
+	for _, tool := range t.Tools {
+		status, path := "ok", tool.Found
+		if !tool.OK() {
+			status = "MISSING"
+			if tool.Found != "" {
+				status = "BROKEN"
+			}
+			path = tool.Path
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", tool.Name, status, tool.Version, path)
+	}
+	tw.Flush()
+
+	for _, tool := range t.Tools {
+		if tool.OK() {
+			continue
+		}
+		fmt.Fprintf(&buf, "\n%s: %s\n", tool.Name, tool.Err)
+		if tool.Fallback != "" {
+			fmt.Fprintf(&buf, "  %s\n", tool.Fallback)
+		} else if len(tool.Disables) > 0 {
+			fmt.Fprintf(&buf, "  disabled: %s\n", strings.Join(tool.Disables, ", "))
+		}
+	}
+
+	if disabled := t.Disabled(); len(disabled) > 0 {
+		fmt.Fprintf(&buf, "\ndisabled: %s\n", strings.Join(disabled, ", "))
+	} else if t.OK() {
+		fmt.Fprintln(&buf, "\nall tools are working")
+	}
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// Runs a tool with a timeout and returns its combined output.
+func runTool(stdin string, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), toolCheckTimeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cm := exec.CommandContext(ctx, name, args...)
+	cm.Stdin = strings.NewReader(stdin)
+	cm.Stdout = &out
+	cm.Stderr = &out
+	if err := cm.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("timed out after %s", toolCheckTimeout)
+		}
+		return "", fmt.Errorf("%s: %s", err, firstLine(out.String()))
+	}
+	return out.String(), nil
+}
+
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// Picks the line of --version output that holds the version.
+func versionLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if strings.Contains(strings.ToLower(line), "version") {
+			return strings.TrimSpace(line)
+		}
+	}
+	return firstLine(s)
+}
+
+func checkTool(name, path string, disables []string) *ToolStatus {
+	s := &ToolStatus{
+		Name:     name,
+		Path:     path,
+		Disables: disables,
+	}
+
+	found, err := exec.LookPath(path)
+	if err != nil {
+		s.Err = err
+		return s
+	}
+	s.Found = found
+
+	out, err := runTool("", found, "--version")
+	if err != nil {
+		s.Err = err
+		return s
+	}
+	s.Version = versionLine(out)
+	return s
+}
+
+// Checks sass and names the implementation that was found, since the
+// command line pork uses depends on it.
+func checkSass() *ToolStatus {
+	s := checkTool("sass", PathToSass, []string{scssFileExtension})
+	if !s.OK() {
+		return s
+	}
+
+	if f := parseSassFlavor(s.Version); !strings.Contains(s.Version, "Sass") {
+		s.Version = fmt.Sprintf("%s %s", f, s.Version)
+	}
+	return s
+}
+
+// Checks closure-compiler, which is run from the bundled jar with java
+// when there is no closure-compiler on the PATH.
+func checkClosureCompiler() *ToolStatus {
+	s := checkTool("closure-compiler", PathToClosureCompiler, nil)
+	if s.OK() {
+		return s
+	}
+
+	if _, err := os.Stat(pathToJsc()); err != nil {
+		return s
+	}
+
+	java, err := exec.LookPath(PathToJava)
+	if err != nil {
+		s.Err = fmt.Errorf("%s, and %s to run %s", s.Err, err, pathToJsc())
+		return s
+	}
+
+	out, err := runTool("", java, "-jar", pathToJsc(), "--version")
+	if err != nil {
+		s.Found = java
+		s.Err = err
+		return s
+	}
+
+	s.Found = java + " -jar " + pathToJsc()
+	s.Version = versionLine(out)
+	s.Err = nil
+	return s
+}
+
+// CheckToolchain locates each of the external tools used by pork and
+// reports its version and whether it works.
+func CheckToolchain(c *Config) *Toolchain {
+	sass := checkSass()
+	tsc := checkTool("tsc", PathToTsc, []string{tscFileExtension, tsxFileExtension})
+
+	// in a modern mode, tsc compiles .main.jsx and jsx is not needed
+	tools := []*ToolStatus{sass, tsc}
+	if c.JsxMode == LegacyJsxMode {
+		tools = append(tools, checkTool("jsx", PathToJsx, []string{jsxFileExtension}))
+	} else {
+		tsc.Disables = append(tsc.Disables, jsxFileExtension)
+	}
+
+	jsc := checkClosureCompiler()
+	if !jsc.OK() && c.JsOptimizer != ClosureJsOptimizer {
+		jsc.Fallback = "JavaScript will be optimized with the built-in minifier"
+	} else {
+		jsc.Disables = []string{"JavaScript optimization"}
+	}
+
+	return &Toolchain{
+		Tools: append(tools, jsc),
+	}
+}
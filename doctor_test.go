@@ -0,0 +1,194 @@
+package pork
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Stand-ins for the tools that report a version, or fail to.
+const (
+	rubySassStub   = "#!/bin/sh\necho 'Ruby Sass 3.7.4'\n"
+	oldSassStub    = "#!/bin/sh\necho 'Sass 3.4.22 (Selective Steve)'\n"
+	dartSassStub   = "#!/bin/sh\necho '1.77.8 compiled with dart2js 3.4.0'\n"
+	tscStub        = "#!/bin/sh\necho 'Version 5.4.5'\n"
+	jsxStub        = "#!/bin/sh\necho 'jsx 0.9.89'\n"
+	closureStub    = "#!/bin/sh\necho 'Closure Compiler (https://github.com/google/closure-compiler)'\necho 'Version: v20240317'\n"
+	javaStub       = "#!/bin/sh\n[ \"$1\" = -jar ] || exit 1\necho 'Version: v20230802'\n"
+	brokenToolStub = "#!/bin/sh\necho 'cannot find module' >&2\nexit 1\n"
+)
+
+func TestCheckToolchain(t *testing.T) {
+	tests := []struct {
+		name        string
+		tools       map[string]string
+		jar         bool
+		jsxMode     string
+		jsOptimizer JsOptimizer
+
+		// the status of each tool: its version when it works, otherwise
+		// missing or broken
+		expected map[string]string
+		disabled []string
+	}{
+		{
+			name: "missing",
+			expected: map[string]string{
+				"sass":             "missing",
+				"tsc":              "missing",
+				"jsx":              "missing",
+				"closure-compiler": "missing",
+			},
+			disabled: []string{scssFileExtension, tscFileExtension, tsxFileExtension, jsxFileExtension},
+		},
+		{
+			name: "ruby sass",
+			tools: map[string]string{
+				"sass":             rubySassStub,
+				"tsc":              tscStub,
+				"jsx":              jsxStub,
+				"closure-compiler": closureStub,
+			},
+			expected: map[string]string{
+				"sass":             "Ruby Sass 3.7.4",
+				"tsc":              "Version 5.4.5",
+				"jsx":              "jsx 0.9.89",
+				"closure-compiler": "Version: v20240317",
+			},
+		},
+		{
+			name:  "old ruby sass",
+			tools: map[string]string{"sass": oldSassStub},
+			expected: map[string]string{
+				"sass":             "Sass 3.4.22 (Selective Steve)",
+				"tsc":              "missing",
+				"jsx":              "missing",
+				"closure-compiler": "missing",
+			},
+			disabled: []string{tscFileExtension, tsxFileExtension, jsxFileExtension},
+		},
+		{
+			name:  "dart sass",
+			tools: map[string]string{"sass": dartSassStub, "tsc": brokenToolStub},
+			expected: map[string]string{
+				"sass":             "Dart Sass 1.77.8 compiled with dart2js 3.4.0",
+				"tsc":              "broken",
+				"jsx":              "missing",
+				"closure-compiler": "missing",
+			},
+			disabled: []string{tscFileExtension, tsxFileExtension, jsxFileExtension},
+		},
+		{
+			name:  "java -jar",
+			tools: map[string]string{"java": javaStub},
+			jar:   true,
+			expected: map[string]string{
+				"sass":             "missing",
+				"tsc":              "missing",
+				"jsx":              "missing",
+				"closure-compiler": "Version: v20230802",
+			},
+			disabled: []string{scssFileExtension, tscFileExtension, tsxFileExtension, jsxFileExtension},
+		},
+		{
+			name:  "java -jar without java",
+			jar:   true,
+			tools: map[string]string{"closure-compiler": brokenToolStub},
+			expected: map[string]string{
+				"sass":             "missing",
+				"tsc":              "missing",
+				"jsx":              "missing",
+				"closure-compiler": "broken",
+			},
+			disabled: []string{scssFileExtension, tscFileExtension, tsxFileExtension, jsxFileExtension},
+		},
+		{
+			name:        "closure required",
+			jsOptimizer: ClosureJsOptimizer,
+			tools:       map[string]string{"sass": dartSassStub, "tsc": tscStub, "jsx": jsxStub},
+			expected: map[string]string{
+				"sass":             "Dart Sass 1.77.8 compiled with dart2js 3.4.0",
+				"tsc":              "Version 5.4.5",
+				"jsx":              "jsx 0.9.89",
+				"closure-compiler": "missing",
+			},
+			disabled: []string{"JavaScript optimization"},
+		},
+		{
+			// tsc compiles .main.jsx, so jsx is not checked at all
+			name:    "jsx mode",
+			jsxMode: "react",
+			tools:   map[string]string{"sass": dartSassStub},
+			expected: map[string]string{
+				"sass":             "Dart Sass 1.77.8 compiled with dart2js 3.4.0",
+				"tsc":              "missing",
+				"closure-compiler": "missing",
+			},
+			disabled: []string{tscFileExtension, tsxFileExtension, jsxFileExtension},
+		},
+	}
+
+	defer func(path, root string) {
+		os.Setenv("PATH", path)
+		rootDir = root
+	}(os.Getenv("PATH"), rootDir)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeFiles(t, test.tools)
+			defer os.RemoveAll(dir)
+
+			for name := range test.tools {
+				if err := os.Chmod(filepath.Join(dir, name), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			os.Setenv("PATH", dir)
+
+			rootDir = dir
+			if test.jar {
+				jar := filepath.Join(dir, "deps", "closure", "compiler.jar")
+				if err := ensureDir(filepath.Dir(jar)); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(jar, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := NewConfig(None)
+			c.JsxMode = test.jsxMode
+			c.JsOptimizer = test.jsOptimizer
+			tc := CheckToolchain(c)
+
+			status := map[string]string{}
+			for _, tool := range tc.Tools {
+				switch {
+				case tool.OK():
+					status[tool.Name] = tool.Version
+				case tool.Found != "":
+					status[tool.Name] = "broken"
+				default:
+					status[tool.Name] = "missing"
+				}
+			}
+
+			if !reflect.DeepEqual(status, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, status)
+			}
+
+			if disabled := tc.Disabled(); !reflect.DeepEqual(disabled, test.disabled) {
+				t.Errorf("expected %v to be disabled, got %v", test.disabled, disabled)
+			}
+
+			// closure-compiler is checked last
+			jsc := tc.Tools[len(tc.Tools)-1]
+			if jsc.OK() && test.jar && !strings.HasSuffix(jsc.Found, " -jar "+pathToJsc()) {
+				t.Errorf("expected closure-compiler to run with java -jar, got %s", jsc.Found)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package pork
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sync"
+)
+
+// A compilation that is in progress and the requests waiting on it.
+type flight struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	refs   int
+	value  interface{}
+	err    error
+
+	// what the work has written so far
+	out *flightOutput
+}
+
+// The output of a flight, which every caller copies as it is written.
+type flightOutput struct {
+	lock    sync.Mutex
+	data    []byte
+	changed chan struct{}
+}
+
+func newFlightOutput() *flightOutput {
+	return &flightOutput{changed: make(chan struct{})}
+}
+
+func (o *flightOutput) Write(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.data = append(o.data, p...)
+	close(o.changed)
+	o.changed = make(chan struct{})
+	return len(p), nil
+}
+
+// Returns what has been written beyond the first n bytes and a channel
+// that is closed by the next write.
+func (o *flightOutput) since(n int) ([]byte, <-chan struct{}) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.data[n:], o.changed
+}
+
+// Groups concurrent calls with the same key so that the work is done once
+// and the result shared. The work is only canceled once every caller
+// waiting on it has given up.
+type flightGroup struct {
+	lock    sync.Mutex
+	flights map[interface{}]*flight
+}
+
+// Joins the flight for key, starting fn in a new flight if there is
+// none.
+func (g *flightGroup) join(key interface{},
+	fn func(context.Context, *flight) (interface{}, error)) *flight {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.flights == nil {
+		g.flights = map[interface{}]*flight{}
+	}
+
+	f, ok := g.flights[key]
+	if !ok {
+		fctx, cancel := context.WithCancel(context.Background())
+		f = &flight{
+			done:   make(chan struct{}),
+			cancel: cancel,
+			out:    newFlightOutput(),
+		}
+		g.flights[key] = f
+
+		go func() {
+			f.value, f.err = fn(fctx, f)
+			cancel()
+
+			g.lock.Lock()
+			if g.flights[key] == f {
+				delete(g.flights, key)
+			}
+			g.lock.Unlock()
+
+			close(f.done)
+		}()
+	}
+	f.refs++
+	return f
+}
+
+// Gives up on a flight, which is canceled once every caller has.
+func (g *flightGroup) leave(key interface{}, f *flight) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	f.refs--
+	if f.refs == 0 {
+		// later callers must not join work that is being abandoned
+		if g.flights[key] == f {
+			delete(g.flights, key)
+		}
+		f.cancel()
+	}
+}
+
+func (g *flightGroup) do(ctx context.Context, key interface{},
+	fn func(context.Context) (interface{}, error)) (interface{}, error) {
+	f := g.join(key, func(ctx context.Context, _ *flight) (interface{}, error) {
+		return fn(ctx)
+	})
+
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		g.leave(key, f)
+		return nil, ctx.Err()
+	}
+}
+
+// Is do for work that writes its result, which is copied into w as it is
+// written rather than once the work is done. A caller that joins late
+// first gets everything that was written before it joined.
+func (g *flightGroup) stream(ctx context.Context, key interface{},
+	fn func(context.Context, io.Writer) error, w io.Writer) error {
+	f := g.join(key, func(ctx context.Context, f *flight) (interface{}, error) {
+		return nil, fn(ctx, f.out)
+	})
+
+	n := 0
+	for {
+		data, changed := f.out.since(n)
+		if len(data) > 0 {
+			if _, err := w.Write(data); err != nil {
+				g.leave(key, f)
+				return err
+			}
+			n += len(data)
+			continue
+		}
+
+		select {
+		case <-changed:
+		case <-f.done:
+			// the work has stopped writing, but w may not have all of it
+			if data, _ := f.out.since(n); len(data) > 0 {
+				if _, err := w.Write(data); err != nil {
+					return err
+				}
+			}
+			return f.err
+		case <-ctx.Done():
+			g.leave(key, f)
+			return ctx.Err()
+		}
+	}
+}
+
+// The compiles in progress in serve mode.
+var compiles flightGroup
+
+type compileKey struct {
+	c   *Config
+	src string
+}
+
+type chunksKey compileKey
+
+// Waits for a free compile slot when Config.MaxConcurrentCompiles is set.
+// The returned function gives the slot back.
+func (c *Config) acquire(ctx context.Context) (func(), error) {
+	if c.MaxConcurrentCompiles <= 0 {
+		return func() {}, nil
+	}
+
+	c.semOnce.Do(func() {
+		c.sem = make(chan struct{}, c.MaxConcurrentCompiles)
+	})
+
+	select {
+	case c.sem <- struct{}{}:
+		return func() { <-c.sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Compiles src into w, sharing the work with any other request that is
+// compiling the same source with the same config. The output is sent to
+// every request as the pipeline produces it, though stages that can only
+// write files, tsc and the closure-compiler workers, still produce theirs
+// all at once.
+func compileShared(ctx context.Context, c *Config, src string, w io.Writer) error {
+	if abs, err := filepath.Abs(src); err == nil {
+		src = abs
+	}
+
+	return compiles.stream(ctx, compileKey{c, src}, func(ctx context.Context, w io.Writer) error {
+		release, err := c.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		return CompileFileContext(ctx, c, src, w)
+	}, w)
+}
+
+// Compiles the chunks of the manifest src, sharing the work with any other
+// request for a chunk of the same manifest.
+func compileChunksShared(ctx context.Context, c *Config, src string) ([]*chunk, error) {
+	if abs, err := filepath.Abs(src); err == nil {
+		src = abs
+	}
+
+	chunks, err := compiles.do(ctx, chunksKey{c, src}, func(ctx context.Context) (interface{}, error) {
+		release, err := c.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		return compileChunks(ctx, c, src)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chunks.([]*chunk), nil
+}
@@ -0,0 +1,105 @@
+package pork
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupShares(t *testing.T) {
+	var g flightGroup
+	var calls int32
+	start := make(chan struct{})
+
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-start
+		return "out", nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]string, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := g.do(context.Background(), "key", fn)
+			if err != nil {
+				t.Error(err)
+			}
+			results[i], _ = v.(string)
+		}(i)
+	}
+
+	// wait for every caller to join the flight
+	for {
+		g.lock.Lock()
+		f := g.flights["key"]
+		joined := f != nil && f.refs == len(results)
+		g.lock.Unlock()
+		if joined {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(start)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("expected 1 call, got %d", calls)
+	}
+
+	for _, res := range results {
+		if res != "out" {
+			t.Fatalf("expected out, got %q", res)
+		}
+	}
+}
+
+func TestFlightGroupCancel(t *testing.T) {
+	var g flightGroup
+	canceled := make(chan struct{})
+
+	fn := func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	if _, err := g.do(ctx, "key", fn); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("work was not canceled after the last caller left")
+	}
+}
+
+func TestConfigAcquire(t *testing.T) {
+	c := NewConfig(None)
+	c.MaxConcurrentCompiles = 1
+
+	release, err := c.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected to wait for a slot, got %v", err)
+	}
+
+	release()
+	release, err = c.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
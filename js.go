@@ -1,16 +1,25 @@
 package pork
 
 import (
+  "bufio"
+  "context"
   "io"
   "os"
 )
 
+// CompileJs expands the preprocessor directives (#include, #define,
+// #ifdef, ...) in src and writes the result to dst. When src is an ES
+// module, it is bundled with the modules it imports.
 func CompileJs(c *Config, src, dst string) error {
-  r, err := os.Open(src)
-  if err != nil {
-    return err
+  return CompileJsContext(context.Background(), c, src, dst)
+}
+
+// CompileJsContext is CompileJs, but it gives up if ctx is done before
+// it starts.
+func CompileJsContext(ctx context.Context, c *Config, src, dst string) error {
+  if ctx.Err() != nil {
+    return contextError(ctx, "preprocess")
   }
-  defer r.Close()
 
   w, err := os.Create(dst)
   if err != nil {
@@ -18,7 +27,27 @@ func CompileJs(c *Config, src, dst string) error {
   }
   defer w.Close()
 
-  _, err = io.Copy(w, r)
+  return streamJs(ctx, c, src, w)
+}
+
+// Expands the preprocessor directives in src into w, bundling it with the
+// modules it imports if it is an ES module.
+func streamJs(ctx context.Context, c *Config, src string, w io.Writer) error {
+  if ctx.Err() != nil {
+    return contextError(ctx, "preprocess")
+  }
+
+  if ok, err := hasJsModuleSyntax(src); err != nil {
+    return err
+  } else if ok {
+    return bundleJs(ctx, c, src, w)
+  }
+
+  // the preprocessor writes a line at a time
+  bw := bufio.NewWriter(w)
+  if err := preprocess(c, src, bw); err != nil {
+    return err
+  }
 
-  return err
+  return bw.Flush()
 }
@@ -0,0 +1,654 @@
+package pork
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A quick test for files that may be ES modules. It is only a hint, the
+// tokens decide.
+var jsModuleSyntaxPattern = regexp.MustCompile(`(?m)^[ \t]*(?:import[\s{*"']|export[\s{*])`)
+
+// A name bound by an import. Imported is "*" for the namespace of the
+// module.
+type jsBinding struct {
+	local    string
+	imported string
+}
+
+// An import or a re-export of another module.
+type jsImport struct {
+	spec     string
+	bindings []jsBinding
+
+	// the file the specifier resolves to
+	path string
+}
+
+// A name exported by a module. Exports taken from another module have a
+// from, in which case local is the name in that module.
+type jsExport struct {
+	name  string
+	local string
+	from  *jsImport
+}
+
+// Replaces the source between from and to with text or, when imp is set,
+// with the declarations of its bindings.
+type jsEdit struct {
+	from, to int
+	text     string
+	imp      *jsImport
+}
+
+// A module in a bundle.
+type jsModule struct {
+	path string
+
+	// the preprocessed source
+	src string
+
+	imports []*jsImport
+	exports []jsExport
+	stars   []*jsImport
+	edits   []jsEdit
+
+	// whether the module has any import or export statements
+	isModule bool
+
+	// every name the module exports, in order, once it has been written
+	names    []string
+	exported map[string]bool
+}
+
+// The local name that holds the default export of a module when it is
+// not a named declaration.
+const jsDefaultLocal = "__pork_default"
+
+// The name of the variable that holds the exports of a module.
+func jsModuleVar(id int) string {
+	return "__pork_m" + strconv.Itoa(id)
+}
+
+func unquoteJsSpec(tok *jsToken) string {
+	return tok.text[1 : len(tok.text)-1]
+}
+
+// Finds the import and export statements at the top level of a module
+// and works out what each of them should be replaced with.
+func parseJsModule(path, src string) (*jsModule, error) {
+	toks, err := tokenizeJs(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	m := &jsModule{path: path, src: src}
+
+	fail := func(i int, format string, args ...interface{}) error {
+		return errorAt(path, lineOf(src, toks[i].pos), format, args...)
+	}
+
+	isPunct := func(i int, text string) bool {
+		return i < len(toks) && toks[i].kind == jsPunct && toks[i].text == text
+	}
+
+	isIdent := func(i int, text string) bool {
+		return i < len(toks) && toks[i].kind == jsIdent && toks[i].text == text
+	}
+
+	// the offset of the end of the statement that ends with token i,
+	// including the semicolon
+	end := func(i int) int {
+		if isPunct(i+1, ";") {
+			i++
+		}
+		return toks[i].pos + len(toks[i].text)
+	}
+
+	// from "spec", starting at the from
+	from := func(i int) (*jsImport, error) {
+		if !isIdent(i, "from") || i+1 >= len(toks) || toks[i+1].kind != jsString {
+			return nil, fail(i-1, "expected from and a module specifier")
+		}
+		imp := &jsImport{spec: unquoteJsSpec(&toks[i+1])}
+		m.imports = append(m.imports, imp)
+		return imp, nil
+	}
+
+	// { a, b as c }, returning the pairs and the index after the }
+	names := func(i int) ([]jsBinding, int, error) {
+		var res []jsBinding
+		for i++; !isPunct(i, "}"); {
+			if i >= len(toks) || toks[i].kind != jsIdent {
+				return nil, 0, fail(i-1, "expected a name")
+			}
+
+			b := jsBinding{local: toks[i].text, imported: toks[i].text}
+			i++
+			if isIdent(i, "as") {
+				if i+1 >= len(toks) || toks[i+1].kind != jsIdent {
+					return nil, 0, fail(i, "expected a name after as")
+				}
+				b.local = toks[i+1].text
+				i += 2
+			}
+			res = append(res, b)
+
+			if isPunct(i, ",") {
+				i++
+			} else if !isPunct(i, "}") {
+				return nil, 0, fail(i-1, "expected , or }")
+			}
+		}
+		return res, i + 1, nil
+	}
+
+	seen := map[string]bool{}
+	export := func(i int, e jsExport) error {
+		if seen[e.name] {
+			return fail(i, "duplicate export %s", e.name)
+		}
+		seen[e.name] = true
+		m.exports = append(m.exports, e)
+		return nil
+	}
+
+	depth := 0
+	for i := 0; i < len(toks); i++ {
+		t := &toks[i]
+		if t.kind == jsPunct {
+			switch t.text {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth--
+			}
+			continue
+		}
+
+		if depth != 0 || t.kind != jsIdent || (i > 0 && isPunct(i-1, ".")) {
+			continue
+		}
+
+		switch t.text {
+		case "import":
+			// import() and import.meta are left alone
+			if i+1 >= len(toks) || isPunct(i+1, "(") || isPunct(i+1, ".") {
+				continue
+			}
+			m.isModule = true
+
+			// import "spec"
+			if toks[i+1].kind == jsString {
+				imp := &jsImport{spec: unquoteJsSpec(&toks[i+1])}
+				m.imports = append(m.imports, imp)
+				m.edits = append(m.edits, jsEdit{from: t.pos, to: end(i + 1)})
+				i++
+				continue
+			}
+
+			var bindings []jsBinding
+			j := i + 1
+			if toks[j].kind == jsIdent && toks[j].text != "from" {
+				bindings = append(bindings, jsBinding{local: toks[j].text, imported: "default"})
+				j++
+				if isPunct(j, ",") {
+					j++
+				}
+			}
+
+			switch {
+			case isPunct(j, "*"):
+				if !isIdent(j+1, "as") || j+2 >= len(toks) || toks[j+2].kind != jsIdent {
+					return nil, fail(j, "expected * as name")
+				}
+				bindings = append(bindings, jsBinding{local: toks[j+2].text, imported: "*"})
+				j += 3
+			case isPunct(j, "{"):
+				b, k, err := names(j)
+				if err != nil {
+					return nil, err
+				}
+				bindings = append(bindings, b...)
+				j = k
+			}
+
+			imp, err := from(j)
+			if err != nil {
+				return nil, err
+			}
+			imp.bindings = bindings
+			m.edits = append(m.edits, jsEdit{from: t.pos, to: end(j + 1), imp: imp})
+			i = j + 1
+		case "export":
+			if i+1 >= len(toks) {
+				return nil, fail(i, "expected a declaration after export")
+			}
+			m.isModule = true
+
+			j := i + 1
+			n := &toks[j]
+			switch {
+			case n.kind == jsIdent && n.text == "default":
+				k := j + 1
+				if isIdent(k, "async") {
+					k++
+				}
+				decl := isIdent(k, "function") || isIdent(k, "class")
+				if isIdent(k, "function") && isPunct(k+1, "*") {
+					k++
+				}
+
+				// export default function name() {} keeps its declaration
+				if decl && k+1 < len(toks) && toks[k+1].kind == jsIdent && toks[k+1].text != "extends" {
+					if err := export(i, jsExport{name: "default", local: toks[k+1].text}); err != nil {
+						return nil, err
+					}
+					m.edits = append(m.edits, jsEdit{from: t.pos, to: toks[j+1].pos})
+				} else {
+					if err := export(i, jsExport{name: "default", local: jsDefaultLocal}); err != nil {
+						return nil, err
+					}
+					m.edits = append(m.edits, jsEdit{
+						from: t.pos,
+						to:   n.pos + len(n.text),
+						text: "var " + jsDefaultLocal + " =",
+					})
+				}
+				i = j
+			case n.kind == jsIdent && (n.text == "function" || n.text == "async" || n.text == "class"):
+				k := j
+				if n.text == "async" {
+					k++
+				}
+				if isPunct(k+1, "*") {
+					k++
+				}
+				if k+1 >= len(toks) || toks[k+1].kind != jsIdent {
+					return nil, fail(i, "expected a name after export %s", n.text)
+				}
+				if err := export(i, jsExport{name: toks[k+1].text, local: toks[k+1].text}); err != nil {
+					return nil, err
+				}
+				m.edits = append(m.edits, jsEdit{from: t.pos, to: n.pos})
+				i = j
+			case n.kind == jsIdent && (n.text == "var" || n.text == "let" || n.text == "const"):
+				k, err := jsDeclaredNames(toks, j+1, func(name string) error {
+					return export(i, jsExport{name: name, local: name})
+				})
+				if err != nil {
+					return nil, fail(i, "%s", err)
+				}
+				m.edits = append(m.edits, jsEdit{from: t.pos, to: n.pos})
+				i = k - 1
+			case isPunct(j, "{"):
+				b, k, err := names(j)
+				if err != nil {
+					return nil, err
+				}
+
+				var imp *jsImport
+				if isIdent(k, "from") {
+					if imp, err = from(k); err != nil {
+						return nil, err
+					}
+					k++
+				} else {
+					k--
+				}
+
+				for _, b := range b {
+					// in an export list, the local name comes first
+					if err := export(i, jsExport{name: b.local, local: b.imported, from: imp}); err != nil {
+						return nil, err
+					}
+				}
+				m.edits = append(m.edits, jsEdit{from: t.pos, to: end(k)})
+				i = k
+			case isPunct(j, "*"):
+				k := j + 1
+				name := ""
+				if isIdent(k, "as") {
+					if k+1 >= len(toks) || toks[k+1].kind != jsIdent {
+						return nil, fail(k, "expected a name after as")
+					}
+					name = toks[k+1].text
+					k += 2
+				}
+
+				imp, err := from(k)
+				if err != nil {
+					return nil, err
+				}
+
+				if name == "" {
+					m.stars = append(m.stars, imp)
+				} else if err := export(i, jsExport{name: name, local: "*", from: imp}); err != nil {
+					return nil, err
+				}
+				m.edits = append(m.edits, jsEdit{from: t.pos, to: end(k + 1)})
+				i = k + 1
+			default:
+				return nil, fail(i, "unsupported export")
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// Collects the names declared by the var, let or const declaration whose
+// first declarator starts at token i and returns the index of the first
+// token after the declaration. Only plain names can be exported.
+func jsDeclaredNames(toks []jsToken, i int, fn func(name string) error) (int, error) {
+	for {
+		if i >= len(toks) || toks[i].kind != jsIdent {
+			return 0, fmt.Errorf("only plain names can be exported from a declaration")
+		}
+		if err := fn(toks[i].text); err != nil {
+			return 0, err
+		}
+
+		// skip to the next declarator or the end of the declaration
+		depth := 0
+	next:
+		for i++; i < len(toks); i++ {
+			t := &toks[i]
+			if depth == 0 && t.nl && t.kind == jsIdent && jsEndsExpression(&toks[i-1]) &&
+				t.text != "in" && t.text != "instanceof" {
+				return i, nil
+			}
+
+			if t.kind != jsPunct {
+				continue
+			}
+
+			switch t.text {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth--
+			}
+
+			if depth == 0 && t.text == ";" {
+				return i + 1, nil
+			}
+			if depth == 0 && t.text == "," {
+				break next
+			}
+		}
+
+		if i >= len(toks) {
+			return i, nil
+		}
+		i++
+	}
+}
+
+// Finds the file for an import specifier in a module in dir. Relative
+// specifiers are resolved against dir and all others against the
+// JsIncludes. The .js can be left off and a directory stands for its
+// index.js.
+func resolveJsImport(c *Config, dir, spec string) string {
+	roots := c.JsIncludes
+	if strings.HasPrefix(spec, "./") || strings.HasPrefix(spec, "../") {
+		roots = []string{dir}
+	}
+
+	name := filepath.FromSlash(spec)
+	for _, root := range roots {
+		base := filepath.Join(root, name)
+		for _, path := range []string{
+			base,
+			base + javaScriptFileExtension,
+			filepath.Join(base, "index"+javaScriptFileExtension),
+		} {
+			if s, err := os.Stat(path); err == nil && !s.IsDir() {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// The line in filename that holds the import of spec. The bundler works
+// on the preprocessed source, so the line is looked for in the original.
+func jsImportLine(filename, spec string) int {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0
+	}
+
+	for i, line := range strings.Split(string(src), "\n") {
+		if strings.Contains(line, `"`+spec+`"`) || strings.Contains(line, `'`+spec+`'`) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Reads, preprocesses and parses the module in filename.
+func readJsModule(c *Config, filename string) (*jsModule, error) {
+	var buf bytes.Buffer
+	if err := preprocess(c, filename, &buf); err != nil {
+		return nil, err
+	}
+	return parseJsModule(filename, buf.String())
+}
+
+// Collects the modules that make up a bundle.
+type jsBundler struct {
+	c       *Config
+	ids     map[string]int
+	modules map[string]*jsModule
+
+	// the modules in the order they must run, each after the modules it
+	// imports
+	order []*jsModule
+}
+
+// Loads the module in path and everything it imports. Stack is the chain
+// of imports that led to path.
+func (b *jsBundler) visit(ctx context.Context, path string, stack []string) error {
+	for i, p := range stack {
+		if p == path {
+			return fmt.Errorf("import cycle: %s", strings.Join(append(stack[i:], path), " -> "))
+		}
+	}
+
+	if _, ok := b.modules[path]; ok {
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return contextError(ctx, "bundle")
+	}
+
+	m, err := readJsModule(b.c, path)
+	if err != nil {
+		return err
+	}
+	b.ids[path] = len(b.ids)
+
+	stack = append(stack, path)
+	for _, imp := range m.imports {
+		imp.path = resolveJsImport(b.c, filepath.Dir(path), imp.spec)
+		if imp.path == "" {
+			return errorAt(path, jsImportLine(path, imp.spec), "cannot resolve import %q", imp.spec)
+		}
+
+		if err := b.visit(ctx, imp.path, stack); err != nil {
+			return err
+		}
+	}
+
+	b.modules[path] = m
+	b.order = append(b.order, m)
+	return nil
+}
+
+// Writes m as a function that runs the module and returns its exports.
+func (b *jsBundler) write(w io.Writer, m *jsModule) error {
+	// a script has nothing to bind and may rely on not being strict, so it
+	// runs as it is and exports nothing
+	if !m.isModule {
+		m.exported = map[string]bool{}
+		_, err := fmt.Fprintf(w, "%s\nvar %s = {};\n", m.src, jsModuleVar(b.ids[m.path]))
+		return err
+	}
+
+	lookup := func(imp *jsImport, name string) (string, error) {
+		v := jsModuleVar(b.ids[imp.path])
+		if name == "*" {
+			return v, nil
+		}
+		if !b.modules[imp.path].exported[name] {
+			return "", errorAt(m.path, jsImportLine(m.path, imp.spec), "%s does not export %s",
+				imp.spec, name)
+		}
+		return v + "." + name, nil
+	}
+
+	var body bytes.Buffer
+	last := 0
+	for _, e := range m.edits {
+		body.WriteString(m.src[last:e.from])
+		body.WriteString(e.text)
+		if e.imp != nil && len(e.imp.bindings) > 0 {
+			var decls []string
+			for _, bn := range e.imp.bindings {
+				v, err := lookup(e.imp, bn.imported)
+				if err != nil {
+					return err
+				}
+				decls = append(decls, bn.local+" = "+v)
+			}
+			body.WriteString("var " + strings.Join(decls, ", ") + ";")
+		}
+		last = e.to
+	}
+	body.WriteString(m.src[last:])
+
+	m.exported = map[string]bool{}
+	var getters []string
+	add := func(name, expr string) {
+		m.names = append(m.names, name)
+		m.exported[name] = true
+		getters = append(getters, fmt.Sprintf("get %s() { return %s; }", name, expr))
+	}
+
+	for _, e := range m.exports {
+		expr := e.local
+		if e.from != nil {
+			v, err := lookup(e.from, e.local)
+			if err != nil {
+				return err
+			}
+			expr = v
+		}
+		add(e.name, expr)
+	}
+
+	// export * leaves out the default and anything the module exports
+	// itself
+	for _, imp := range m.stars {
+		from := b.modules[imp.path]
+		for _, name := range from.names {
+			if !m.exported[name] && name != "default" {
+				add(name, jsModuleVar(b.ids[imp.path])+"."+name)
+			}
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "var %s = (function() {\n\"use strict\";\n%s\nreturn {%s};\n})();\n",
+		jsModuleVar(b.ids[m.path]), body.String(), strings.Join(getters, ", "))
+	return err
+}
+
+// Bundles the ES module in src with every module it imports, in the
+// order they must run, into a single script. Each module runs in its own
+// strict function. Files without module syntax, which are only imported
+// for their side effects, are written as they are, so that they are not
+// strict, though their top-level declarations are still local to the
+// bundle. A src that neither imports nor exports anything is written as
+// it is.
+//
+// Named and default imports copy the value that the module they come
+// from exported once it had run, so unlike real ES modules they do not
+// see later assignments to it. A namespace import (import * as ns) reads
+// the current value on every use.
+func bundleJs(ctx context.Context, c *Config, src string, w io.Writer) error {
+	b := &jsBundler{
+		c:       c,
+		ids:     map[string]int{},
+		modules: map[string]*jsModule{},
+	}
+
+	if err := b.visit(ctx, src, nil); err != nil {
+		return err
+	}
+
+	entry := b.modules[src]
+	if !entry.isModule {
+		_, err := io.WriteString(w, entry.src)
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := io.WriteString(bw, "(function() {\n"); err != nil {
+		return err
+	}
+
+	for _, m := range b.order {
+		if err := b.write(bw, m); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(bw, "})();\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Whether filename looks like an ES module.
+func hasJsModuleSyntax(filename string) (bool, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false, err
+	}
+	return jsModuleSyntaxPattern.Match(src), nil
+}
+
+// Finds the modules that filename imports, if it is a module.
+func jsImportDeps(c *Config, filename string, missing []string) ([]string, []string, error) {
+	if ok, err := hasJsModuleSyntax(filename); err != nil || !ok {
+		return nil, missing, err
+	}
+
+	// a module that cannot be read this way fails when it is built
+	m, err := readJsModule(c, filename)
+	if err != nil {
+		return nil, missing, nil
+	}
+
+	var deps []string
+	for _, imp := range m.imports {
+		path := resolveJsImport(c, filepath.Dir(filename), imp.spec)
+		if path == "" {
+			missing = append(missing, imp.spec)
+			continue
+		}
+		deps = append(deps, path)
+	}
+	return deps, missing, nil
+}
@@ -0,0 +1,131 @@
+package pork
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseJsModule(t *testing.T) {
+	src := "import a, {b as c} from \"./a\";\nimport * as ns from 'ns';\nimport \"side\";\n" +
+		"export default function f() {}\nexport const x = 1, y = [2, 3]\nexport {c as d};\n" +
+		"export * from \"./e\";\nvar z = import(\"lazy\");\n"
+
+	m, err := parseJsModule("a.js", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var specs []string
+	for _, imp := range m.imports {
+		specs = append(specs, imp.spec)
+	}
+	if strings.Join(specs, " ") != "./a ns side ./e" {
+		t.Fatalf("unexpected imports: %v", specs)
+	}
+
+	var names []string
+	for _, e := range m.exports {
+		names = append(names, e.name+"="+e.local)
+	}
+	if strings.Join(names, " ") != "default=f x=x y=y d=c" {
+		t.Fatalf("unexpected exports: %v", names)
+	}
+
+	if len(m.stars) != 1 || !m.isModule {
+		t.Fatalf("expected a module with one export *, got %+v", m)
+	}
+
+	m, err = parseJsModule("b.js", "var a = {import: 1, export: 2};\na.import();\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.isModule {
+		t.Fatalf("expected a script, got %+v", m)
+	}
+}
+
+func TestBundleJs(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"app.main.js":       "#define GREETING \"hi\"\nimport greet, {name} from \"./lib/greet\";\nimport * as counter from \"./lib/counter.js\";\ncounter.inc();\nlog(greet(name), GREETING, counter.count, counter.double(3));\n",
+		"lib/greet.js":      "import {prefix} from \"util\";\nexport const name = \"world\";\nexport default function greet(n) { return prefix + n; }\n",
+		"lib/counter.js":    "export let count = 0;\nexport function inc() { count++; }\nexport * from \"./math.js\";\n",
+		"lib/math.js":       "export const double = (x) => x * 2;\nexport default 3;\n",
+		"inc/util/index.js": "export var prefix = \"hello, \";\n",
+		"plain.main.js":     "var a = 1;\n",
+		"cycle.main.js":     "import \"./b.js\";\n",
+		"b.js":              "import \"./cycle.main.js\";\n",
+		"missing.main.js":   "var a;\nimport {b} from \"./nope\";\n",
+		"export.main.js":    "import {nope} from \"./lib/math\";\n",
+		"legacy.main.js":    "import \"./legacy.js\";\nlog(typeof implicit, legacy);\n",
+		"legacy.js":         "implicit = 1;\nvar legacy = \"sloppy\"\n",
+	})
+	defer os.RemoveAll(dir)
+
+	c := NewConfig(None)
+	c.JsIncludes = []string{filepath.Join(dir, "inc")}
+
+	var buf bytes.Buffer
+	if err := streamJs(context.Background(), c, filepath.Join(dir, "app.main.js"), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), "import") || strings.Contains(buf.String(), "export") {
+		t.Fatalf("expected no imports or exports, got %s", buf.String())
+	}
+
+	if node, err := exec.LookPath("node"); err == nil {
+		script := "var log = function() { console.log([].slice.call(arguments).join(' ')); };\n" + buf.String()
+		out, err := exec.Command(node, "-e", script).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+		if string(out) != "hello, world hi 1 6\n" {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	}
+
+	// a script imported for its side effects is not made strict
+	buf.Reset()
+	if err := streamJs(context.Background(), c, filepath.Join(dir, "legacy.main.js"), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "(function() {\nimplicit = 1;") {
+		t.Fatalf("expected the script as it is, got %s", buf.String())
+	}
+
+	if node, err := exec.LookPath("node"); err == nil {
+		script := "var log = function() { console.log([].slice.call(arguments).join(' ')); };\n" + buf.String()
+		out, err := exec.Command(node, "-e", script).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+		if string(out) != "number sloppy\n" {
+			t.Fatalf("unexpected output: %s", out)
+		}
+	}
+
+	buf.Reset()
+	if err := streamJs(context.Background(), c, filepath.Join(dir, "plain.main.js"), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "var a = 1;\n" {
+		t.Fatalf("expected the script as it is, got %s", buf.String())
+	}
+
+	errs := map[string]string{
+		"cycle.main.js":   "import cycle: " + filepath.Join(dir, "cycle.main.js") + " -> ",
+		"missing.main.js": "missing.main.js:2: cannot resolve import \"./nope\"",
+		"export.main.js":  "./lib/math does not export nope",
+	}
+	for name, expected := range errs {
+		err := streamJs(context.Background(), c, filepath.Join(dir, name), &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected %q, got %v", name, expected, err)
+		}
+	}
+}
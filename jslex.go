@@ -0,0 +1,314 @@
+package pork
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type jsTokenKind int
+
+const (
+	jsIdent jsTokenKind = iota
+	jsNumber
+	jsString
+	jsTemplate
+	jsRegexp
+	jsPunct
+	jsComment
+)
+
+// A single JavaScript token. Comments are only kept as tokens when they
+// must survive minification (/*! ... */ and /*@ ... */).
+type jsToken struct {
+	kind jsTokenKind
+	text string
+
+	// the offset of the token in the source
+	pos int
+
+	// whether a line terminator preceded the token
+	nl bool
+}
+
+var jsPunctuators = []string{
+	">>>=", "...", "===", "!==", "**=", "<<=", ">>=", ">>>", "&&=", "||=", "??=",
+	"=>", "==", "!=", "<=", ">=", "&&", "||", "??", "?.", "++", "--", "+=", "-=",
+	"*=", "/=", "%=", "&=", "|=", "^=", "**", "<<", ">>",
+}
+
+var jsReservedWords = map[string]bool{
+	"break": true, "case": true, "catch": true, "class": true, "const": true,
+	"continue": true, "debugger": true, "default": true, "delete": true,
+	"do": true, "else": true, "enum": true, "export": true, "extends": true,
+	"false": true, "finally": true, "for": true, "function": true, "if": true,
+	"import": true, "in": true, "instanceof": true, "new": true, "null": true,
+	"return": true, "super": true, "switch": true, "this": true, "throw": true,
+	"true": true, "try": true, "typeof": true, "var": true, "void": true,
+	"while": true, "with": true, "yield": true, "let": true, "static": true,
+	"implements": true, "interface": true, "package": true, "private": true,
+	"protected": true, "public": true, "await": true,
+}
+
+// Keywords after which a / begins a regular expression.
+var jsRegexpKeywords = map[string]bool{
+	"return": true, "typeof": true, "instanceof": true, "in": true, "of": true,
+	"new": true, "delete": true, "void": true, "throw": true, "case": true,
+	"do": true, "else": true, "yield": true, "await": true,
+}
+
+func isJsIdentPart(c byte) bool {
+	return isIdentPart(c) || c >= 0x80 || c == '\\'
+}
+
+func isJsIdentStart(c byte) bool {
+	return isIdentStart(c) || c >= 0x80 || c == '\\'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Whether a / following tok starts a regular expression rather than a
+// division.
+func regexpAllowedAfter(tok *jsToken) bool {
+	if tok == nil {
+		return true
+	}
+	switch tok.kind {
+	case jsIdent:
+		return jsRegexpKeywords[tok.text]
+	case jsPunct:
+		return tok.text != ")" && tok.text != "]" && tok.text != "++" && tok.text != "--"
+	}
+	return false
+}
+
+// Finds the end of a quoted string starting at i.
+func scanJsString(src string, i int) (int, error) {
+	q := src[i]
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case q:
+			return j + 1, nil
+		case '\n':
+			return 0, fmt.Errorf("unterminated string literal")
+		}
+	}
+	return 0, fmt.Errorf("unterminated string literal")
+}
+
+// Finds the end of a template literal starting at i, including any
+// nested substitutions.
+func scanJsTemplate(src string, i int) (int, error) {
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '`':
+			return j + 1, nil
+		case '$':
+			if j+1 < len(src) && src[j+1] == '{' {
+				depth := 1
+				for j += 2; j < len(src) && depth > 0; j++ {
+					switch c := src[j]; c {
+					case '{':
+						depth++
+					case '}':
+						depth--
+					case '"', '\'':
+						e, err := scanJsString(src, j)
+						if err != nil {
+							return 0, err
+						}
+						j = e - 1
+					case '`':
+						e, err := scanJsTemplate(src, j)
+						if err != nil {
+							return 0, err
+						}
+						j = e - 1
+					}
+				}
+				j--
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated template literal")
+}
+
+// Finds the end of a regular expression literal starting at i.
+func scanJsRegexp(src string, i int) (int, error) {
+	class := false
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '[':
+			class = true
+		case ']':
+			class = false
+		case '\n':
+			return 0, fmt.Errorf("unterminated regular expression")
+		case '/':
+			if class {
+				continue
+			}
+			j++
+			for j < len(src) && isJsIdentPart(src[j]) {
+				j++
+			}
+			return j, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated regular expression")
+}
+
+func lineOf(src string, i int) int {
+	return strings.Count(src[:i], "\n") + 1
+}
+
+// Splits JavaScript source into tokens.
+func tokenizeJs(src string) ([]jsToken, error) {
+	var toks []jsToken
+	var prev *jsToken
+	nl := false
+	i := 0
+
+	emit := func(kind jsTokenKind, text string) {
+		toks = append(toks, jsToken{kind: kind, text: text, pos: i, nl: nl})
+		if kind != jsComment {
+			prev = &toks[len(toks)-1]
+		}
+		nl = false
+	}
+
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\n' || c == '\r':
+			nl = true
+			i++
+		case c == ' ' || c == '\t' || c == '\f' || c == '\v':
+			i++
+		case strings.HasPrefix(src[i:], "\u2028") || strings.HasPrefix(src[i:], "\u2029"):
+			nl = true
+			i += 3
+		case strings.HasPrefix(src[i:], "\u00a0") || strings.HasPrefix(src[i:], "\ufeff"):
+			_, n := utf8.DecodeRuneInString(src[i:])
+			i += n
+		case strings.HasPrefix(src[i:], "//"):
+			e := strings.IndexByte(src[i:], '\n')
+			if e < 0 {
+				i = len(src)
+			} else {
+				i += e
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			e := strings.Index(src[i+2:], "*/")
+			if e < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", lineOf(src, i))
+			}
+			text := src[i : i+2+e+2]
+			if strings.ContainsAny(text, "\n\r") {
+				nl = true
+			}
+			if strings.HasPrefix(text, "/*!") || strings.HasPrefix(text, "/*@") {
+				n := nl
+				emit(jsComment, text)
+				nl = n
+			}
+			i += len(text)
+		case c == '"' || c == '\'':
+			e, err := scanJsString(src, i)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineOf(src, i), err)
+			}
+			emit(jsString, src[i:e])
+			i = e
+		case c == '`':
+			e, err := scanJsTemplate(src, i)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineOf(src, i), err)
+			}
+			emit(jsTemplate, src[i:e])
+			i = e
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			j := i + 1
+			for j < len(src) {
+				d := src[j]
+				if isJsIdentPart(d) || d == '.' {
+					j++
+				} else if (d == '+' || d == '-') && (src[j-1] == 'e' || src[j-1] == 'E') &&
+					!strings.HasPrefix(strings.ToLower(src[i:j]), "0x") {
+					j++
+				} else {
+					break
+				}
+			}
+			emit(jsNumber, src[i:j])
+			i = j
+		case isJsIdentStart(c) || c == '#':
+			j := i + 1
+			for j < len(src) && isJsIdentPart(src[j]) {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			emit(jsIdent, src[i:j])
+			i = j
+		case c == '/' && regexpAllowedAfter(prev):
+			e, err := scanJsRegexp(src, i)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineOf(src, i), err)
+			}
+			emit(jsRegexp, src[i:e])
+			i = e
+		default:
+			p := src[i : i+1]
+			for _, punct := range jsPunctuators {
+				if strings.HasPrefix(src[i:], punct) {
+					p = punct
+					break
+				}
+			}
+			// a?.5:1 is a conditional, not optional chaining
+			if p == "?." && i+2 < len(src) && isDigit(src[i+2]) {
+				p = "?"
+			}
+			emit(jsPunct, p)
+			i += len(p)
+		}
+	}
+	return toks, nil
+}
+
+// Computes the index of the matching bracket for every (, [ and {.
+func matchJsBrackets(toks []jsToken) (map[int]int, error) {
+	match := map[int]int{}
+	var stack []int
+	for i, t := range toks {
+		if t.kind != jsPunct {
+			continue
+		}
+		switch t.text {
+		case "(", "[", "{":
+			stack = append(stack, i)
+		case ")", "]", "}":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unbalanced %s", t.text)
+			}
+			o := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			match[o] = i
+			match[i] = o
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("unbalanced %s", toks[stack[len(stack)-1]].text)
+	}
+	return match, nil
+}
@@ -0,0 +1,519 @@
+package pork
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Optimization pipe that buffers a script and minifies it on Close.
+type jsMinOpt struct {
+	bytes.Buffer
+	w     io.Writer
+	level Optimization
+}
+
+func (o *jsMinOpt) Close() error {
+	s, err := minifyJs(o.String(), o.level)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(o.w, s)
+	return err
+}
+
+// Keywords that end a statement when followed by a line terminator.
+var jsRestrictedKeywords = map[string]bool{
+	"return": true, "break": true, "continue": true, "throw": true, "yield": true,
+}
+
+// Whether tok can end an expression, which is when a line terminator
+// after it may trigger automatic semicolon insertion.
+func jsEndsExpression(tok *jsToken) bool {
+	switch tok.kind {
+	case jsIdent:
+		if !jsReservedWords[tok.text] {
+			return true
+		}
+		switch tok.text {
+		case "this", "super", "null", "true", "false", "let", "static", "await",
+			"implements", "interface", "package", "private", "protected", "public":
+			return true
+		}
+		return false
+	case jsNumber, jsString, jsTemplate, jsRegexp:
+		return true
+	case jsPunct:
+		switch tok.text {
+		case ")", "]", "}", "++", "--":
+			return true
+		}
+	}
+	return false
+}
+
+// Whether tok cannot continue the expression before it, which means a
+// preceding line terminator may have inserted a semicolon.
+func jsStartsStatement(tok *jsToken) bool {
+	switch tok.kind {
+	case jsIdent, jsNumber, jsString, jsRegexp:
+		return true
+	case jsPunct:
+		switch tok.text {
+		case "{", "!", "~", "++", "--":
+			return true
+		}
+	}
+	return false
+}
+
+// Determines what must separate two adjacent tokens: nothing, a space or
+// a line terminator. prop indicates that prev is a property name, which
+// ends an expression even when it is a keyword.
+func jsSeparator(prev, cur *jsToken, prop bool) string {
+	if prev == nil {
+		return ""
+	}
+
+	if cur.nl {
+		if prev.kind == jsIdent && jsRestrictedKeywords[prev.text] {
+			return "\n"
+		}
+		if cur.kind == jsPunct && (cur.text == "++" || cur.text == "--") {
+			return "\n"
+		}
+		if (prop || jsEndsExpression(prev)) && jsStartsStatement(cur) {
+			return "\n"
+		}
+	}
+
+	if cur.kind == jsComment {
+		return ""
+	}
+
+	p, c := prev.text[len(prev.text)-1], cur.text[0]
+	switch {
+	case isJsIdentPart(p) && isJsIdentPart(c):
+		return " "
+	case prev.kind == jsNumber && c == '.':
+		return " "
+	case (p == '+' || p == '-') && c == p:
+		return " "
+	case p == '/' && (c == '/' || c == '*'):
+		return " "
+	case p == '<' && c == '!', p == '-' && c == '>':
+		return " "
+	}
+	return ""
+}
+
+// Writes tokens with the minimal amount of whitespace between them.
+func writeJsTokens(toks []jsToken) string {
+	var b strings.Builder
+	var prev *jsToken
+	prop := false
+	for i := range toks {
+		cur := &toks[i]
+		if i > 0 && toks[i-1].kind == jsComment {
+			// a kept comment always ends its own line
+			b.WriteString("\n")
+		} else {
+			b.WriteString(jsSeparator(prev, cur, prop))
+		}
+		b.WriteString(cur.text)
+		if cur.kind != jsComment {
+			prop = cur.kind == jsIdent && prev != nil && prev.kind == jsPunct &&
+				(prev.text == "." || prev.text == "?.")
+			prev = cur
+		}
+	}
+	return b.String()
+}
+
+// Minifies a script by removing comments and whitespace. At Advanced,
+// the variables and parameters local to functions are also renamed when
+// it is safe to do so.
+func minifyJs(src string, level Optimization) (string, error) {
+	toks, err := tokenizeJs(src)
+	if err != nil {
+		return "", err
+	}
+
+	if level == Advanced {
+		if err := renameJsLocals(toks); err != nil {
+			return "", err
+		}
+	}
+
+	return writeJsTokens(toks), nil
+}
+
+// A function found in the token stream. params and body hold the token
+// indexes of the opening ( and {.
+type jsFunction struct {
+	params int
+	body   int
+	end    int
+}
+
+func isJsPunct(toks []jsToken, i int, text string) bool {
+	return i >= 0 && i < len(toks) && toks[i].kind == jsPunct && toks[i].text == text
+}
+
+func isJsKeyword(toks []jsToken, i int, text string) bool {
+	return i >= 0 && i < len(toks) && toks[i].kind == jsIdent && toks[i].text == text
+}
+
+// Returns the index of the previous token that is not a comment.
+func prevJsToken(toks []jsToken, i int) int {
+	for i--; i >= 0 && toks[i].kind == jsComment; i-- {
+	}
+	return i
+}
+
+func findJsFunctions(toks []jsToken, match map[int]int) []*jsFunction {
+	var fns []*jsFunction
+	for i := range toks {
+		if !isJsKeyword(toks, i, "function") {
+			continue
+		}
+
+		j := i + 1
+		if isJsPunct(toks, j, "*") {
+			j++
+		}
+		if j < len(toks) && toks[j].kind == jsIdent {
+			j++
+		}
+		if !isJsPunct(toks, j, "(") {
+			continue
+		}
+
+		b := match[j] + 1
+		if !isJsPunct(toks, b, "{") {
+			continue
+		}
+
+		fns = append(fns, &jsFunction{params: j, body: b, end: match[b]})
+	}
+	return fns
+}
+
+// The index of the bracket that encloses token i, or -1 if there is none.
+func jsEnclosingBracket(toks []jsToken, match map[int]int, i int) int {
+	for j := i - 1; j >= 0; j-- {
+		if toks[j].kind != jsPunct {
+			continue
+		}
+		switch toks[j].text {
+		case ")", "]", "}":
+			j = match[j]
+		case "(", "[", "{":
+			return j
+		}
+	}
+	return -1
+}
+
+// Whether the { at i opens a block rather than an object literal.
+func isJsBlockBrace(toks []jsToken, i int) bool {
+	p := prevJsToken(toks, i)
+	if p < 0 {
+		return true
+	}
+
+	t := &toks[p]
+	switch t.kind {
+	case jsPunct:
+		switch t.text {
+		case ")", ";", "{", "}", "=>":
+			return true
+		}
+	case jsIdent:
+		switch t.text {
+		case "else", "do", "try", "finally":
+			return true
+		}
+	}
+	return false
+}
+
+// Whether any token in the range uses a feature that prevents renaming
+// from being done safely.
+func jsUnsafeToRename(toks []jsToken, match map[int]int, from, to int) bool {
+	for i := from; i <= to; i++ {
+		t := &toks[i]
+		switch t.kind {
+		case jsTemplate:
+			return true
+		case jsIdent:
+			switch t.text {
+			case "eval", "with", "class", "get", "set":
+				if !isJsPunct(toks, prevJsToken(toks, i), ".") {
+					return true
+				}
+			}
+
+			if jsReservedWords[t.text] {
+				continue
+			}
+
+			// method shorthand: name(...) { in an object literal or class
+			if isJsPunct(toks, i+1, "(") && isJsPunct(toks, match[i+1]+1, "{") &&
+				!isJsKeyword(toks, prevJsToken(toks, i), "function") {
+				return true
+			}
+
+			// property shorthand: { name } with any other properties
+			// before or after it, in an object literal or pattern
+			p := prevJsToken(toks, i)
+			if (isJsPunct(toks, p, "{") || isJsPunct(toks, p, ",")) &&
+				(isJsPunct(toks, i+1, ",") || isJsPunct(toks, i+1, "}")) {
+				if o := jsEnclosingBracket(toks, match, i); isJsPunct(toks, o, "{") && !isJsBlockBrace(toks, o) {
+					return true
+				}
+			}
+		case jsPunct:
+			switch t.text {
+			case "=>", "...":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Collects the names declared by a var, let or const statement starting
+// at i. Returns false if the declaration uses destructuring.
+func collectJsVarNames(toks []jsToken, i int, names map[string]bool) bool {
+	expectName := true
+	depth := 0
+	for i++; i < len(toks); i++ {
+		t := &toks[i]
+		if t.kind == jsComment {
+			continue
+		}
+
+		if expectName {
+			if t.kind != jsIdent || jsReservedWords[t.text] {
+				return false
+			}
+			names[t.text] = true
+			expectName = false
+			continue
+		}
+
+		if depth == 0 && t.nl && jsEndsExpression(&toks[prevJsToken(toks, i)]) && jsStartsStatement(t) &&
+			!(t.kind == jsIdent && (t.text == "in" || t.text == "of" || t.text == "instanceof")) {
+			return true
+		}
+
+		if t.kind == jsPunct {
+			switch t.text {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				if depth == 0 {
+					return true
+				}
+				depth--
+			case ";":
+				if depth == 0 {
+					return true
+				}
+			case ",":
+				if depth == 0 {
+					expectName = true
+				}
+			}
+		} else if depth == 0 && t.kind == jsIdent && (t.text == "in" || t.text == "of") {
+			return true
+		}
+	}
+	return true
+}
+
+// Collects the names declared directly in a function (not in functions
+// nested inside of it). Returns false if the function cannot be renamed.
+func collectJsDeclarations(toks []jsToken, fn *jsFunction, fns []*jsFunction, match map[int]int) (map[string]bool, bool) {
+	names := map[string]bool{}
+
+	// parameters must be plain identifiers
+	expectName := true
+	for i := fn.params + 1; i < match[fn.params]; i++ {
+		t := &toks[i]
+		switch {
+		case t.kind == jsComment:
+		case expectName && t.kind == jsIdent && !jsReservedWords[t.text]:
+			names[t.text] = true
+			expectName = false
+		case !expectName && isJsPunct(toks, i, ","):
+			expectName = true
+		default:
+			return nil, false
+		}
+	}
+
+	nested := map[int]*jsFunction{}
+	for _, f := range fns {
+		if f.body > fn.body && f.end < fn.end {
+			nested[f.params] = f
+		}
+	}
+
+	for i := fn.body + 1; i < fn.end; i++ {
+		t := &toks[i]
+		if t.kind != jsIdent {
+			continue
+		}
+
+		switch t.text {
+		case "var", "let", "const":
+			if isJsPunct(toks, prevJsToken(toks, i), ".") {
+				continue
+			}
+			if !collectJsVarNames(toks, i, names) {
+				return nil, false
+			}
+		case "function":
+			j := i + 1
+			if isJsPunct(toks, j, "*") {
+				j++
+			}
+			if j < len(toks) && toks[j].kind == jsIdent {
+				// only declarations bind their name in this scope
+				p := prevJsToken(toks, i)
+				if p < 0 || isJsPunct(toks, p, ";") || isJsPunct(toks, p, "{") || isJsPunct(toks, p, "}") {
+					names[toks[j].text] = true
+				}
+				j++
+			}
+
+			// skip over the nested function entirely
+			if f, ok := nested[j]; ok {
+				i = f.end
+			}
+		}
+	}
+
+	return names, true
+}
+
+// Generates the nth short name: a, b, ... z, A, ... Z, aa, ab, ...
+func jsShortName(n int) string {
+	const first = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_$"
+	const rest = first + "0123456789"
+	name := []byte{first[n%len(first)]}
+	for n /= len(first); n > 0; n /= len(rest) {
+		n--
+		name = append(name, rest[n%len(rest)])
+	}
+	return string(name)
+}
+
+// Whether the identifier at i refers to a binding (rather than being a
+// property name).
+func isJsPropertyName(toks []jsToken, i int) bool {
+	p := prevJsToken(toks, i)
+	if isJsPunct(toks, p, ".") || isJsPunct(toks, p, "?.") {
+		return true
+	}
+	return isJsPunct(toks, i+1, ":") && (isJsPunct(toks, p, "{") || isJsPunct(toks, p, ","))
+}
+
+// Names whose uses cannot be told apart from labels or other non-binding
+// uses are never renamed.
+func isJsAmbiguousName(toks []jsToken, i int) bool {
+	p := prevJsToken(toks, i)
+	if isJsKeyword(toks, p, "break") || isJsKeyword(toks, p, "continue") {
+		return true
+	}
+	if isJsPunct(toks, i+1, ":") && !isJsPunct(toks, p, "{") && !isJsPunct(toks, p, ",") &&
+		!isJsPunct(toks, p, "?") && !isJsKeyword(toks, p, "case") {
+		return true
+	}
+	return false
+}
+
+func renameJsFunction(toks []jsToken, fn *jsFunction, fns []*jsFunction, match map[int]int) {
+	if jsUnsafeToRename(toks, match, fn.params, fn.end) {
+		return
+	}
+
+	names, ok := collectJsDeclarations(toks, fn, fns, match)
+	if !ok || len(names) == 0 {
+		return
+	}
+
+	used := map[string]bool{}
+	counts := map[string]int{}
+	for i := fn.params; i <= fn.end; i++ {
+		t := &toks[i]
+		if t.kind != jsIdent {
+			continue
+		}
+		used[t.text] = true
+		if !names[t.text] || isJsPropertyName(toks, i) {
+			continue
+		}
+		if isJsAmbiguousName(toks, i) {
+			delete(names, t.text)
+			continue
+		}
+		counts[t.text]++
+	}
+
+	var order []string
+	for name := range names {
+		order = append(order, name)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if counts[order[i]] != counts[order[j]] {
+			return counts[order[i]] > counts[order[j]]
+		}
+		return order[i] < order[j]
+	})
+
+	renames := map[string]string{}
+	n := 0
+	for _, name := range order {
+		for {
+			short := jsShortName(n)
+			n++
+			if !used[short] && !jsReservedWords[short] {
+				if len(short) < len(name) {
+					renames[name] = short
+				} else {
+					n--
+				}
+				break
+			}
+		}
+	}
+
+	for i := fn.params; i <= fn.end; i++ {
+		t := &toks[i]
+		if t.kind != jsIdent || isJsPropertyName(toks, i) {
+			continue
+		}
+		if r, ok := renames[t.text]; ok {
+			t.text = r
+		}
+	}
+}
+
+// Renames the locals of every function, outermost first so that the
+// names chosen for inner functions never capture an outer one.
+func renameJsLocals(toks []jsToken) error {
+	match, err := matchJsBrackets(toks)
+	if err != nil {
+		return err
+	}
+
+	fns := findJsFunctions(toks, match)
+	for _, fn := range fns {
+		renameJsFunction(toks, fn, fns, match)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package pork
+
+import "testing"
+
+func TestMinifyJs(t *testing.T) {
+	tests := []struct {
+		level    Optimization
+		src      string
+		expected string
+	}{
+		{Basic, "var a = 1 ; // one\nvar b = a + +a;", "var a=1;var b=a+ +a;"},
+		{Basic, "/*! keep */\nfoo( 'a  b' , /x y/g );", "/*! keep */\nfoo('a  b',/x y/g);"},
+		{Basic, "a = b\n++c\nreturn\nx", "a=b\n++c\nreturn\nx"},
+		{Basic, "var x = a\n(b)", "var x=a(b)"},
+		{Basic, "x = y / 2 / z; r = 1 .toString()", "x=y/2/z;r=1 .toString()"},
+		{Advanced, "function foo(alpha, beta) { var gamma = alpha + beta; return gamma.gamma; }",
+			"function foo(a,b){var c=a+b;return c.gamma;}"},
+		{Advanced, "function f(value) { return { value: value, o: g.value }; }",
+			"function f(a){return{value:a,o:g.value};}"},
+		{Advanced, "function f(outer) { return function(inner) { return outer + inner + a; }; }",
+			"function f(b){return function(c){return b+c+a;};}"},
+		{Advanced, "function f(x) { return eval('x'); }", "function f(x){return eval('x');}"},
+		{Advanced, "function f(item) { return { item }; }", "function f(item){return{item};}"},
+		{Advanced, "function f(item) { return { x: 1, item, y: 2 }; }",
+			"function f(item){return{x:1,item,y:2};}"},
+		{Advanced, "function f(alpha, beta) { if (alpha) { alpha, beta } return beta; }",
+			"function f(a,b){if(a){a,b}return b;}"},
+	}
+
+	for _, test := range tests {
+		res, err := minifyJs(test.src, test.level)
+		if err != nil {
+			t.Errorf("minify %q: %s", test.src, err)
+			continue
+		}
+
+		if res != test.expected {
+			t.Errorf("minify %q: expected %q, got %q", test.src, test.expected, res)
+		}
+	}
+}
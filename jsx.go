@@ -1,13 +1,18 @@
 package pork
 
 import (
-  "os"
+  "context"
+  "io"
   "os/exec"
   "path/filepath"
 )
 
-func jsxCommand(c *Config, src, dst string) *exec.Cmd {
-  args := []string{"--output", dst}
+func jsxCommand(ctx context.Context, c *Config, src, dst string) *exec.Cmd {
+  // without an --output, jsx writes to stdout
+  var args []string
+  if dst != "" {
+    args = append(args, "--output", dst)
+  }
 
   for _, i := range c.JsxIncludes {
     args = append(args, "--add-search-path", i)
@@ -24,17 +29,35 @@ func jsxCommand(c *Config, src, dst string) *exec.Cmd {
   }
 
   args = append(args, filepath.Base(src))
-  cm := exec.Command(PathToJsx, args...)
+  cm := exec.CommandContext(ctx, PathToJsx, args...)
 
   // For jsx, we execute with a difference cwd to avoid having
   // absolute paths in the class map.
   cm.Dir = filepath.Dir(src)
-
-  cm.Stderr = os.Stderr
-  cm.Stdout = os.Stdout
   return cm
 }
 
 func CompileJsx(c *Config, src, dst string) error {
-  return jsxCommand(c, src, dst).Run()
+  return CompileJsxContext(context.Background(), c, src, dst)
+}
+
+// CompileJsxContext compiles src into dst with jsx, or with tsc when
+// Config.JsxMode is a modern mode, which is killed if ctx is done before
+// it finishes.
+func CompileJsxContext(ctx context.Context, c *Config, src, dst string) error {
+  if c.JsxMode != LegacyJsxMode {
+    return CompileTscContext(ctx, c, src, dst)
+  }
+  return runCompiler(ctx, c, "jsx", src, jsxCommand(ctx, c, src, dst), parseJsxDiagnostics)
+}
+
+// Compiles src with jsx, streaming the output into w.
+func streamJsx(ctx context.Context, c *Config, src string, w io.Writer) error {
+  if c.JsxMode != LegacyJsxMode {
+    return streamTsc(ctx, c, src, w)
+  }
+
+  cm := jsxCommand(ctx, c, src, "")
+  cm.Stdout = w
+  return runCompiler(ctx, c, "jsx", src, cm, parseJsxDiagnostics)
 }
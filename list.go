@@ -0,0 +1,234 @@
+package pork
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A .main.list manifest, which concatenates a fixed list of scripts or
+// stylesheets.
+type list struct {
+	// dstOfJs or dstOfCSS
+	typ dstType
+
+	files []string
+
+	// the lines that name no files
+	missing []string
+}
+
+// # type: js or # type: css
+var listTypePattern = regexp.MustCompile(`^#\s*type\s*:\s*(\S*)\s*$`)
+
+// The kind of output a file in a list is, judging by its extension.
+func listTypeOfFile(filename string) dstType {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case javaScriptFileExtension:
+		return dstOfJs
+	case cssFileExtension:
+		return dstOfCSS
+	}
+	return dstOfUnknown
+}
+
+// Reads a .main.list manifest without failing on lines that name no
+// files. Each line is a path or a glob relative to the manifest and the
+// files are concatenated in the order they are listed, with the matches
+// of a glob in lexical order. A file is only included the first time it
+// is listed.
+//
+//	# type: js
+//	vendor/jquery.js
+//	vendor/plugins/*.js
+//
+// Without a # type: line, the type comes from the extensions of the files,
+// which must then all be .js or all be .css.
+func parseList(filename string) (*list, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(filename)
+	l := &list{typ: dstOfUnknown}
+	declared := false
+	seen := map[string]bool{}
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			m := listTypePattern.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+
+			if declared || len(l.files) > 0 || len(l.missing) > 0 {
+				return nil, errorAt(filename, n, "the type must come before the files")
+			}
+
+			switch strings.ToLower(m[1]) {
+			case "js":
+				l.typ = dstOfJs
+			case "css":
+				l.typ = dstOfCSS
+			default:
+				return nil, errorAt(filename, n, "invalid type: %s (expected js or css)", m[1])
+			}
+			declared = true
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(line)))
+		if err != nil {
+			return nil, errorAt(filename, n, "invalid pattern: %s", line)
+		}
+
+		var files []string
+		for _, match := range matches {
+			if s, err := os.Stat(match); err == nil && !s.IsDir() {
+				files = append(files, match)
+			}
+		}
+
+		if len(files) == 0 {
+			l.missing = append(l.missing, line)
+			continue
+		}
+
+		for _, file := range files {
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+
+			if !declared {
+				t := listTypeOfFile(file)
+				if t == dstOfUnknown {
+					return nil, errorAt(filename, n,
+						"%s is neither .js nor .css, add a # type: line", file)
+				}
+
+				if l.typ != dstOfUnknown && l.typ != t {
+					return nil, errorAt(filename, n, "%s does not have the same type as the files before it", file)
+				}
+				l.typ = t
+			}
+			l.files = append(l.files, file)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(l.files) == 0 && len(l.missing) == 0 {
+		return nil, fmt.Errorf("%s: no files", filename)
+	}
+	return l, nil
+}
+
+// Reads a .main.list manifest, which must name only files that exist.
+func readList(filename string) (*list, error) {
+	l, err := parseList(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(l.missing) > 0 {
+		return nil, fmt.Errorf("%s: no files match %s", filename, strings.Join(l.missing, ", "))
+	}
+	return l, nil
+}
+
+// The output path of the manifest src.
+func listPath(src string, typ dstType) string {
+	if typ == dstOfCSS {
+		return changeTypeOfFile(src, listFileExtension, cssFileExtension)
+	}
+	return changeTypeOfFile(src, listFileExtension, javaScriptFileExtension)
+}
+
+// Whether a request for a dst of type typ can be answered by the manifest
+// src. A manifest that cannot be read answers both, so that the request
+// reports why.
+func listAnswers(src string, typ dstType) bool {
+	l, err := parseList(src)
+	return err != nil || l.typ == typ || l.typ == dstOfUnknown
+}
+
+// The optimizer for the output of a list.
+func listOptimizer(typ dstType) optimizer {
+	if typ == dstOfCSS {
+		return optimizeCss
+	}
+	return optimizeJs
+}
+
+// The byte order mark and @charset rule that may only come at the very
+// start of a stylesheet.
+var cssCharsetPattern = regexp.MustCompile(`^\x{feff}?(?:@charset\s*(?:"[^"]*"|'[^']*')\s*;[ \t]*\r?\n?)?`)
+
+// Concatenates the files in the manifest src into w. Each file is
+// followed by a line terminator if it does not end with one. Scripts are
+// separated by a semicolon, so that one that leaves its last statement
+// open does not run into the next, and stylesheets only keep the byte
+// order mark and @charset of the first of them.
+func streamList(ctx context.Context, c *Config, src string, w io.Writer) error {
+	l, err := readList(src)
+	if err != nil {
+		return err
+	}
+
+	for i, file := range l.files {
+		if ctx.Err() != nil {
+			return contextError(ctx, "list")
+		}
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			switch l.typ {
+			case dstOfJs:
+				if _, err := io.WriteString(w, ";\n"); err != nil {
+					return err
+				}
+			case dstOfCSS:
+				data = cssCharsetPattern.ReplaceAll(data, nil)
+			}
+		}
+
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			data = append(data, '\n')
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finds the files listed in a .main.list manifest.
+func listDeps(filename string, missing []string) ([]string, []string, error) {
+	l, err := parseList(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return l.files, append(missing, l.missing...), nil
+}
@@ -0,0 +1,139 @@
+package pork
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadList(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"js.main.list":      "# vendor scripts\nvendor/b.js\nvendor/*.js\n",
+		"typed.main.list":   "# type: css\nvendor/*.txt\n",
+		"vendor/a.js":       "",
+		"vendor/b.js":       "",
+		"vendor/c.txt":      "",
+		"vendor/d.css":      "",
+		"mixed.main.list":   "vendor/a.js\nvendor/d.css\n",
+		"unknown.main.list": "vendor/c.txt\n",
+		"invalid.main.list": "# type: html\nvendor/a.js\n",
+		"late.main.list":    "vendor/a.js\n# type: js\n",
+		"missing.main.list": "vendor/a.js\nvendor/*.png\n",
+		"empty.main.list":   "# nothing\n",
+	})
+	defer os.RemoveAll(dir)
+
+	path := func(name string) string {
+		return filepath.Join(dir, filepath.FromSlash(name))
+	}
+
+	tests := map[string]*list{
+		"js.main.list":    {typ: dstOfJs, files: []string{path("vendor/b.js"), path("vendor/a.js")}},
+		"typed.main.list": {typ: dstOfCSS, files: []string{path("vendor/c.txt")}},
+	}
+	for name, expected := range tests {
+		l, err := readList(path(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(l, expected) {
+			t.Errorf("%s: expected %+v, got %+v", name, expected, l)
+		}
+	}
+
+	for _, name := range []string{"mixed", "unknown", "invalid", "late", "missing", "empty"} {
+		if _, err := readList(path(name + ".main.list")); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	l, err := parseList(path("missing.main.list"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(l.missing, []string{"vendor/*.png"}) {
+		t.Errorf("expected vendor/*.png to be missing, got %v", l.missing)
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"src/app.main.list":     "lib/*.js\n",
+		"src/lib/a.js":          "var a = 1;",
+		"src/lib/b.js":          "var b = 2;\n",
+		"src/style.main.list":   "# type: css\nlib/reset.css\n",
+		"src/lib/reset.css":     "a { b: c; }\n",
+		"src/css.main.list":     "lib/reset.css\nlib/charset/*.css\n",
+		"src/lib/charset/a.css": "@charset \"utf-8\";\nb { c: d; }\n",
+		"src/lib/charset/b.css": "\xef\xbb\xbf@charset 'utf-8';\nc { d: e; }",
+	})
+	defer os.RemoveAll(dir)
+
+	c := NewConfig(None)
+
+	var buf bytes.Buffer
+	if err := CompileFile(c, filepath.Join(dir, "src/app.main.list"), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "var a = 1;\n;\nvar b = 2;\n" {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+
+	// only the first stylesheet may have a @charset
+	buf.Reset()
+	if err := CompileFile(c, filepath.Join(dir, "src/css.main.list"), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "a { b: c; }\nb { c: d; }\nc { d: e; }\n" {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+
+	tests := map[string]string{
+		"/app.js":    filepath.Join(dir, "src/app.main.list"),
+		"/style.css": filepath.Join(dir, "src/style.main.list"),
+		"/app.css":   "",
+		"/style.js":  "",
+	}
+	for pth, expected := range tests {
+		r, err := http.NewRequest("GET", pth, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := FindContent("/", r, http.Dir(filepath.Join(dir, "src")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected == "" {
+			if res != nil {
+				t.Errorf("%s: expected nothing, got %+v", pth, res)
+			}
+		} else if res == nil || res.srcType != srcOfList || res.srcFile != expected {
+			t.Errorf("%s: expected %s, got %+v", pth, expected, res)
+		}
+	}
+
+	out := filepath.Join(dir, "out")
+	if _, err := Content(c, http.Dir(filepath.Join(dir, "src"))).Productionize(http.Dir(out)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{
+		"app.js":    "var a = 1;\n;\nvar b = 2;\n",
+		"style.css": "a { b: c; }\n",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, data)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package pork
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The file, written into the destination of Productionize, that records
+// which outputs pork owns. It is never served.
+const manifestFileName = ".pork-manifest"
+
+type manifest struct {
+	// output paths relative to the destination mapped to the path of the
+	// source that produced them relative to its root
+	Outputs map[string]string `json:"outputs"`
+}
+
+func pathToManifest(dest http.Dir) string {
+	return filepath.Join(string(dest), manifestFileName)
+}
+
+func readManifest(dest http.Dir) (*manifest, error) {
+	m := &manifest{Outputs: map[string]string{}}
+
+	r, err := os.Open(pathToManifest(dest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+	defer r.Close()
+
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, err
+	}
+
+	if m.Outputs == nil {
+		m.Outputs = map[string]string{}
+	}
+	return m, nil
+}
+
+// Loads the outputs recorded by previous builds into dest. An output
+// that is not beneath dest is an error, since it would later be removed.
+func (b *build) load(dest http.Dir) error {
+	m, err := readManifest(dest)
+	if err != nil {
+		return err
+	}
+
+	for dst, src := range m.Outputs {
+		path := filepath.Join(string(dest), filepath.FromSlash(dst))
+		rel, err := filepath.Rel(string(dest), path)
+		if err != nil || rel == "." || rel == ".." ||
+			strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s: %s is not in %s", pathToManifest(dest), dst, dest)
+		}
+		b.outputs[path] = src
+	}
+	return nil
+}
+
+// Writes the outputs of the build into the manifest in dest.
+func (b *build) save(dest http.Dir) error {
+	m := &manifest{Outputs: map[string]string{}}
+	for dst, src := range b.outputs {
+		rel, err := filepath.Rel(string(dest), dst)
+		if err != nil {
+			return err
+		}
+		m.Outputs[filepath.ToSlash(rel)] = src
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := ensureDir(string(dest)); err != nil {
+		return err
+	}
+
+	f, err := os.Create(pathToManifest(dest))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Removes directories, up to but not including dest, that are left empty
+// after removing the file at path.
+func removeEmptyDirs(dest http.Dir, path string) {
+	stop, err := filepath.Abs(string(dest))
+	if err != nil {
+		return
+	}
+
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		abs, err := filepath.Abs(dir)
+		if err != nil || abs == stop || len(abs) < len(stop) {
+			return
+		}
+
+		// Remove fails on directories that are not empty
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
+// Whether the source src, as recorded in the manifest, is one of the
+// files for which affected returns true in any of roots.
+func (b *build) covers(roots []http.Dir, src string, affected func(string) bool) bool {
+	if filepath.IsAbs(src) {
+		return affected(src)
+	}
+
+	for _, root := range roots {
+		if affected(filepath.Join(string(root), filepath.FromSlash(src))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes the outputs that were recorded by earlier builds but not
+// produced by the one that just succeeded: those of sources that are gone
+// and those that a source no longer produces, such as a chunk dropped from
+// a .main.modules. After a build of only the affected files, only the
+// outputs of those files are considered.
+func (b *build) removeStale(dest http.Dir, roots []http.Dir, affected func(string) bool) error {
+	var stale []string
+	for dst, src := range b.outputs {
+		if b.produced[dst] {
+			continue
+		}
+		if affected == nil || b.covers(roots, src, affected) {
+			stale = append(stale, dst)
+		}
+	}
+	sort.Strings(stale)
+
+	for _, dst := range stale {
+		if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(b.outputs, dst)
+		removeEmptyDirs(dest, dst)
+	}
+	return nil
+}
+
+// Clean removes every file that Productionize generated in dest, leaving
+// any other files in place.
+func Clean(dest http.Dir) error {
+	b := newBuild()
+	if err := b.load(dest); err != nil {
+		return err
+	}
+
+	var outputs []string
+	for dst := range b.outputs {
+		outputs = append(outputs, dst)
+	}
+	sort.Strings(outputs)
+
+	for _, dst := range outputs {
+		if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removeEmptyDirs(dest, dst)
+	}
+
+	if err := os.Remove(pathToManifest(dest)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package pork
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestReadManifest(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"bad/" + manifestFileName:     "{",
+		"outside/" + manifestFileName: `{"outputs": {"../x.js": "x.main.js"}}`,
+		"ok/" + manifestFileName:      `{"outputs": {"a/b.js": "a/b.main.js"}}`,
+	})
+	defer os.RemoveAll(dir)
+
+	m, err := readManifest(http.Dir(filepath.Join(dir, "none")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Outputs) != 0 {
+		t.Fatalf("expected no outputs, got %v", m.Outputs)
+	}
+
+	if _, err := readManifest(http.Dir(filepath.Join(dir, "bad"))); err == nil {
+		t.Fatal("expected an error for an invalid manifest")
+	}
+
+	if err := newBuild().load(http.Dir(filepath.Join(dir, "outside"))); err == nil {
+		t.Fatal("expected an error for an output outside of the destination")
+	}
+
+	b := newBuild()
+	if err := b.load(http.Dir(filepath.Join(dir, "ok"))); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{filepath.Join(dir, "ok", "a", "b.js"): "a/b.main.js"}
+	if !reflect.DeepEqual(b.outputs, expected) {
+		t.Fatalf("expected %v, got %v", expected, b.outputs)
+	}
+}
+
+// Renaming a source removes its old output, along with the directories
+// that leaves empty, and so does a list that changes type.
+func TestRemoveStale(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"src/sub/dir/a.main.js": "var a;\n",
+		"src/x.main.list":       "lib/*.js\n",
+		"src/lib/l.js":          "var l;\n",
+		"src/lib/s.css":         "a { b: c; }\n",
+	})
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	out := filepath.Join(dir, "out")
+
+	c := NewConfig(None)
+	c.RemoveStale = true
+
+	if _, err := Content(c, http.Dir(src)).Productionize(http.Dir(out)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"sub/dir/a.js", "x.js"} {
+		if !exists(filepath.Join(out, name)) {
+			t.Fatalf("expected %s to be built", name)
+		}
+	}
+
+	if err := os.Rename(filepath.Join(src, "sub/dir/a.main.js"), filepath.Join(src, "b.main.js")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "x.main.list"), []byte("lib/*.css\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Content(c, http.Dir(src)).Productionize(http.Dir(out)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]bool{
+		"b.js":         true,
+		"x.css":        true,
+		"sub/dir/a.js": false,
+		"sub":          false,
+		"x.js":         false,
+	} {
+		if exists(filepath.Join(out, name)) != expected {
+			t.Errorf("%s: expected exists to be %t", name, expected)
+		}
+	}
+
+	m, err := readManifest(http.Dir(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Outputs["b.js"] != "b.main.js" {
+		t.Errorf("expected b.js to come from b.main.js, got %q", m.Outputs["b.js"])
+	}
+}
+
+// Clean only removes what pork built and stops removing empty directories
+// at the destination.
+func TestClean(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"src/sub/dir/a.main.js": "var a;\n",
+		"src/b.main.js":         "var b;\n",
+		"out/sub/mine.txt":      "mine",
+	})
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	if _, err := Content(NewConfig(None), http.Dir(filepath.Join(dir, "src"))).Productionize(http.Dir(out)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Clean(http.Dir(out)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]bool{
+		"":               true,
+		"sub/mine.txt":   true,
+		"sub/dir":        false,
+		"b.js":           false,
+		manifestFileName: false,
+	} {
+		if exists(filepath.Join(out, name)) != expected {
+			t.Errorf("%q: expected exists to be %t", name, expected)
+		}
+	}
+
+	// everything pork built is gone, but the destination is not
+	if err := os.Remove(filepath.Join(out, "sub/mine.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Content(NewConfig(None), http.Dir(filepath.Join(dir, "src"))).Productionize(http.Dir(out)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Clean(http.Dir(out)); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := ioutil.ReadDir(out); err != nil || len(names) != 0 {
+		t.Errorf("expected %s to be empty, got %v, %v", out, names, err)
+	}
+
+	// a manifest that names a file outside of the destination is refused
+	victim := filepath.Join(dir, "victim.txt")
+	if err := ioutil.WriteFile(victim, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := `{"outputs": {"../victim.txt": "victim.main.js"}}`
+	if err := ioutil.WriteFile(filepath.Join(out, manifestFileName), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Clean(http.Dir(out)); err == nil {
+		t.Error("expected an error for an output outside of the destination")
+	}
+	if !exists(victim) {
+		t.Error("expected victim.txt to survive")
+	}
+}
+
+// Building in place leaves the sources alone and never serves the
+// manifest.
+func TestProductionizeInPlace(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.main.js":   "var a;\n",
+		"page.html":   "<html></html>",
+		"lib/util.js": "var util;\n",
+	})
+	defer os.RemoveAll(dir)
+
+	h := Content(NewConfig(None), http.Dir(dir))
+	if _, err := h.Productionize(http.Dir(dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := readManifest(http.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.Outputs, map[string]string{"a.js": "a.main.js"}) {
+		t.Fatalf("unexpected outputs: %v", m.Outputs)
+	}
+
+	r, err := http.NewRequest("GET", "/"+manifestFileName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := FindContent("/", r, http.Dir(dir)); err != nil || res != nil {
+		t.Fatalf("expected the manifest not to be served, got %+v, %v", res, err)
+	}
+
+	if err := Clean(http.Dir(dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]bool{
+		"a.main.js":      true,
+		"page.html":      true,
+		"lib/util.js":    true,
+		"a.js":           false,
+		manifestFileName: false,
+	} {
+		if exists(filepath.Join(dir, name)) != expected {
+			t.Errorf("%s: expected exists to be %t", name, expected)
+		}
+	}
+}
@@ -1,14 +1,24 @@
 package pork
 
 import (
+  "bytes"
+  "context"
+  "fmt"
   "io"
+  "io/ioutil"
   "os"
   "os/exec"
+  "sort"
+  "strings"
 )
 
 type jsOpt struct {
   io.WriteCloser
-  cm *exec.Cmd
+  cm     *exec.Cmd
+  ctx    context.Context
+  c      *Config
+  src    string
+  stderr bytes.Buffer
 }
 
 func (o *jsOpt) Close() error {
@@ -16,7 +26,101 @@ func (o *jsOpt) Close() error {
     return err
   }
 
-  return o.cm.Wait()
+  if err := o.cm.Wait(); err != nil {
+    if o.ctx.Err() != nil {
+      return contextError(o.ctx, "closure-compiler")
+    }
+    return newCompileError("closure-compiler", o.src, o.stderr.String(), err,
+      parseClosureDiagnostics)
+  }
+
+  return reportWarnings(o.c, "closure-compiler", o.src, o.stderr.String(),
+    parseClosureDiagnostics)
+}
+
+// Passes the warnings in the output of a tool that succeeded to the
+// Config's WarningLogger, or to stderr when there is none. When warnings
+// are errors, they fail the compile instead.
+func reportWarnings(c *Config, tool, src, out string, parse diagParser) error {
+  if out == "" {
+    return nil
+  }
+
+  diags := parse(out)
+  warnings := warningsOf(diags)
+
+  if c.WarningsAsErrors && len(warnings) > 0 {
+    return &CompileError{
+      Tool:        tool,
+      Src:         src,
+      Diagnostics: diags,
+      Output:      out,
+      Err:         ErrWarnings,
+    }
+  }
+
+  logWarnings(c, src, out, warnings)
+  return nil
+}
+
+// Passes the warnings parsed from out to the Config's WarningLogger, or
+// writes all of out to stderr when there is none.
+func logWarnings(c *Config, src, out string, warnings []Diagnostic) {
+  if c.WarningLogger == nil {
+    os.Stderr.WriteString(out)
+    return
+  }
+
+  if len(warnings) > 0 {
+    c.WarningLogger(src, warnings)
+  }
+}
+
+// Optimizes JavaScript with a closure-compiler worker. Workers read and
+// write files rather than streams, so the input is collected in a temp
+// file.
+type jscWorkerOpt struct {
+  *os.File
+  ctx context.Context
+  c   *Config
+  src string
+  w   io.Writer
+}
+
+func (o *jscWorkerOpt) Close() error {
+  in := o.File.Name()
+  defer os.Remove(in)
+
+  if err := o.File.Close(); err != nil {
+    return err
+  }
+
+  out := in + ".js"
+  defer os.Remove(out)
+
+  req := &workerRequest{
+    Src:  o.src,
+    Args: append(jscArgs(o.c), "--js", in, "--js_output_file", out),
+  }
+
+  res, err := o.c.workerPools().jsc.call(o.ctx, req)
+  if err != nil {
+    return err
+  }
+
+  // report the input the same way as when it is piped to closure-compiler
+  diags := strings.Replace(res.Diagnostics, in, "stdin", -1)
+
+  if res.Failed {
+    return newCompileError("closure-compiler", o.src, diags, errWorkerCompile,
+      parseClosureDiagnostics)
+  }
+
+  if err := catFile(o.w, out); err != nil {
+    return err
+  }
+
+  return reportWarnings(o.c, "closure-compiler", o.src, diags, parseClosureDiagnostics)
 }
 
 type noOpt struct {
@@ -27,55 +131,160 @@ func (o *noOpt) Close() error {
   return nil
 }
 
-func jscCommand(externs []string, jscPath string, level Optimization) *exec.Cmd {
-  args := []string{"--language_in", "ECMASCRIPT5"}
+// Finds the command that runs closure-compiler. The wrapper named by
+// PathToClosureCompiler is used when it is on the PATH, otherwise the
+// bundled jar is run with java.
+func closureCompiler() ([]string, error) {
+  path, err := exec.LookPath(PathToClosureCompiler)
+  if err == nil {
+    return []string{path}, nil
+  }
+
+  if _, serr := os.Stat(pathToJsc()); serr != nil {
+    return nil, err
+  }
 
-  switch level {
+  java, err := exec.LookPath(PathToJava)
+  if err != nil {
+    return nil, err
+  }
+  return []string{java, "-jar", pathToJsc()}, nil
+}
+
+// Formats a --define for closure-compiler. Strings are quoted so that
+// closure-compiler does not take them for booleans or numbers.
+func jscDefine(name string, v interface{}) string {
+  if s, ok := v.(string); ok {
+    q := "'"
+    if strings.Contains(s, q) {
+      q = "\""
+    }
+    return name + "=" + q + s + q
+  }
+  return fmt.Sprintf("%s=%v", name, v)
+}
+
+func jscArgs(c *Config) []string {
+  in := c.JscLanguageIn
+  if in == "" {
+    in = "ECMASCRIPT5"
+  }
+  args := []string{"--language_in", in}
+
+  if c.JscLanguageOut != "" {
+    args = append(args, "--language_out", c.JscLanguageOut)
+  }
+
+  switch c.Level {
   case Basic:
     args = append(args, "--compilation_level", "SIMPLE_OPTIMIZATIONS")
   case Advanced:
     args = append(args, "--compilation_level", "ADVANCED_OPTIMIZATIONS")
   }
 
-  for _, e := range externs {
+  for _, e := range c.JsxExterns {
     args = append(args, "--externs", e)
   }
 
-  return exec.Command(PathToClosureCompiler, args...)
+  names := make([]string, 0, len(c.JscDefines))
+  for name := range c.JscDefines {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  for _, name := range names {
+    args = append(args, "--define", jscDefine(name, c.JscDefines[name]))
+  }
+
+  for _, w := range c.JscWarnings {
+    args = append(args, "--jscomp_warning", w)
+  }
+
+  for _, e := range c.JscErrors {
+    args = append(args, "--jscomp_error", e)
+  }
+
+  return args
+}
+
+func jscCommand(ctx context.Context, c *Config) (*exec.Cmd, error) {
+  jsc, err := closureCompiler()
+  if err != nil {
+    return nil, fmt.Errorf("closure-compiler: %s", err)
+  }
+
+  args := append(jsc[1:], jscArgs(c)...)
+  return exec.CommandContext(ctx, jsc[0], args...), nil
+}
+
+// Determines whether JavaScript should be optimized with closure-compiler
+// or with the built-in minifier.
+func useClosureCompiler(c *Config) bool {
+  switch c.JsOptimizer {
+  case ClosureJsOptimizer:
+    return true
+  case BuiltinJsOptimizer:
+    return false
+  }
+  _, err := closureCompiler()
+  return err == nil
 }
 
 // Creates an optimization pipe for JavaScript streams
-func optimizeJs(c *Config, w io.Writer) (io.WriteCloser, error) {
+func optimizeJs(ctx context.Context, c *Config, src string, w io.Writer) (io.WriteCloser, error) {
   switch c.Level {
   case Basic, Advanced:
-    cm := jscCommand(c.JsxExterns, pathToJsc(), c.Level)
+    if !useClosureCompiler(c) {
+      return &jsMinOpt{w: w, level: c.Level}, nil
+    }
+
+    if c.Workers > 0 {
+      f, err := ioutil.TempFile(os.TempDir(), "jsc-")
+      if err != nil {
+        return nil, err
+      }
+      return &jscWorkerOpt{File: f, ctx: ctx, c: c, src: src, w: w}, nil
+    }
+
+    cm, err := jscCommand(ctx, c)
+    if err != nil {
+      return nil, err
+    }
+
+    o := &jsOpt{
+      cm:  cm,
+      ctx: ctx,
+      c:   c,
+      src: src,
+    }
 
     // connect the output of the command to the writer
     cm.Stdout = w
 
-    // send all error spew to the orginal stderr stream
-    cm.Stderr = os.Stderr
+    // capture the error spew for diagnostics
+    cm.Stderr = &o.stderr
 
     // open a pipe into stdin
     wc, err := cm.StdinPipe()
     if err != nil {
       return nil, err
     }
+    o.WriteCloser = wc
 
     // fire that off in the background
     if err := cm.Start(); err != nil {
       return nil, err
     }
 
-    return &jsOpt{
-      WriteCloser: wc,
-      cm:          cm,
-    }, nil
+    return o, nil
   }
   return &noOpt{Writer: w}, nil
 }
 
-// Creates an optimization pipe for JavaScript streams
-func optimizeCss(c *Config, w io.Writer) (io.WriteCloser, error) {
+// Creates an optimization pipe for CSS streams
+func optimizeCss(ctx context.Context, c *Config, src string, w io.Writer) (io.WriteCloser, error) {
+  switch c.Level {
+  case Basic, Advanced:
+    return &cssOpt{w: w, level: c.Level}, nil
+  }
   return &noOpt{Writer: w}, nil
 }
@@ -0,0 +1,75 @@
+package pork
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJscArgs(t *testing.T) {
+	c := NewConfig(Advanced)
+	c.JsxExterns = []string{"externs.js"}
+	c.JscLanguageIn = "ECMASCRIPT_2015"
+	c.JscLanguageOut = "ECMASCRIPT5"
+	c.JscDefines = map[string]interface{}{
+		"DEBUG":   false,
+		"LIMIT":   float64(10),
+		"VERSION": "1.2",
+		"QUOTE":   "it's",
+	}
+	c.JscWarnings = []string{"checkTypes"}
+	c.JscErrors = []string{"checkVars"}
+
+	expected := []string{
+		"--language_in", "ECMASCRIPT_2015",
+		"--language_out", "ECMASCRIPT5",
+		"--compilation_level", "ADVANCED_OPTIMIZATIONS",
+		"--externs", "externs.js",
+		"--define", "DEBUG=false",
+		"--define", "LIMIT=10",
+		"--define", "QUOTE=\"it's\"",
+		"--define", "VERSION='1.2'",
+		"--jscomp_warning", "checkTypes",
+		"--jscomp_error", "checkVars",
+	}
+	if actual := jscArgs(c); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+
+	expected = []string{"--language_in", "ECMASCRIPT5", "--compilation_level", "SIMPLE_OPTIMIZATIONS"}
+	if actual := jscArgs(NewConfig(Basic)); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestReportWarnings(t *testing.T) {
+	out := "stdin:3:5: WARNING - [JSC_UNUSED] unused\n" +
+		"stdin:9: WARNING - [JSC_TYPE] mismatch\n" +
+		"0 error(s), 2 warning(s)\n"
+
+	var src string
+	var warnings []Diagnostic
+	c := NewConfig(Basic)
+	c.WarningLogger = func(s string, w []Diagnostic) {
+		src, warnings = s, w
+	}
+
+	if err := reportWarnings(c, "closure-compiler", "a.main.js", out, parseClosureDiagnostics); err != nil {
+		t.Fatal(err)
+	}
+
+	if src != "a.main.js" || len(warnings) != 2 {
+		t.Fatalf("expected 2 warnings for a.main.js, got %d for %s", len(warnings), src)
+	}
+
+	c.WarningsAsErrors = true
+	err := reportWarnings(c, "closure-compiler", "a.main.js", out, parseClosureDiagnostics)
+	if !errors.Is(err, ErrWarnings) {
+		t.Fatalf("expected warnings to be errors, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "[JSC_UNUSED] unused") {
+		t.Fatalf("expected the warnings in the error, got %s", err)
+	}
+}
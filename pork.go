@@ -3,6 +3,8 @@ package pork
 import (
 	"bufio"
 	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -36,8 +38,11 @@ type srcType int
 const (
 	srcOfJsx srcType = iota
 	srcOfTsc
+	srcOfTsx
 	srcOfScss
 	srcOfJs
+	srcOfModules
+	srcOfList
 	srcOfUnknown
 )
 
@@ -53,9 +58,16 @@ const (
 	// src
 	jsxFileExtension  = ".main.jsx"
 	tscFileExtension  = ".main.ts"
+	tsxFileExtension  = ".main.tsx"
 	scssFileExtension = ".main.scss"
 	jsFileExtension   = ".main.js"
 
+	// a manifest of chunks, each of which is built into <base>.<chunk>.js
+	modulesFileExtension = ".main.modules"
+
+	// a list of files that are concatenated into a .js or a .css
+	listFileExtension = ".main.list"
+
 	// dst
 	javaScriptFileExtension = ".js"
 	cssFileExtension        = ".css"
@@ -63,10 +75,31 @@ const (
 
 var excludedSrcExtensions = []string{
 	".ts",
+	".tsx",
 	".jsx",
 	".scss",
 }
 
+// JsOptimizer selects the tool used to optimize JavaScript at the Basic
+// and Advanced levels.
+type JsOptimizer int
+
+const (
+	// AutoJsOptimizer uses closure-compiler when it can be found and the
+	// built-in minifier otherwise.
+	AutoJsOptimizer JsOptimizer = iota
+
+	// ClosureJsOptimizer always uses closure-compiler.
+	ClosureJsOptimizer
+
+	// BuiltinJsOptimizer always uses the built-in minifier.
+	BuiltinJsOptimizer
+)
+
+// LegacyJsxMode is the Config.JsxMode that compiles .main.jsx with the
+// jsx compiler.
+const LegacyJsxMode = ""
+
 // PathToSass ...
 var PathToSass = "sass"
 
@@ -79,6 +112,10 @@ var PathToTsc = "tsc"
 // PathToClosureCompiler ...
 var PathToClosureCompiler = "closure-compiler"
 
+// PathToJava is used to run the bundled closure-compiler jar when there is
+// no closure-compiler on the PATH.
+var PathToJava = "java"
+
 var rootDir string
 
 func pathToJsc() string {
@@ -220,6 +257,9 @@ func (d *router) RespondWithFunc(path string, f func(ResponseWriter, *http.Reque
 type Handler interface {
 	Responder
 	Productionize(d http.Dir) (func() error, error)
+
+	// Close stops the compiler workers that the handler started.
+	io.Closer
 }
 
 // Responder ...
@@ -371,8 +411,78 @@ type Config struct {
 	JsxIncludes  []string
 	JsxExterns   []string
 	ScssIncludes []string
+	JsIncludes   []string
+	JsOptimizer  JsOptimizer
+
+	// JsxMode selects how .main.jsx is compiled. LegacyJsxMode uses the
+	// jsx compiler. Otherwise it is the value for tsc's --jsx and
+	// .main.jsx is compiled with tsc, as .main.tsx always is. Only react
+	// compiles into a single script, so other modes fail. When the mode is legacy, .main.tsx uses the jsx
+	// option of its tsconfig.json, or react if it has none.
+	JsxMode string
+
+	// JscLanguageIn and JscLanguageOut are the language levels given to
+	// closure-compiler (ECMASCRIPT5, ECMASCRIPT_2015, ...). The input
+	// defaults to ECMASCRIPT5 and the output to closure-compiler's default.
+	JscLanguageIn  string
+	JscLanguageOut string
+
+	// JscDefines overrides @define constants when closure-compiler
+	// optimizes JavaScript. Values are bools, numbers or strings.
+	JscDefines map[string]interface{}
+
+	// JscWarnings and JscErrors are closure-compiler diagnostic groups
+	// (checkTypes, missingProperties, ...) to report as warnings or errors.
+	JscWarnings []string
+	JscErrors   []string
+
+	// BuildLogger, if set, is called for each output written by
+	// Productionize.
+	BuildLogger func(src, dst string)
+
+	// RemoveStale causes Productionize to delete the outputs it created
+	// in earlier builds that a successful build no longer produces, such
+	// as those of a source that was removed or renamed.
+	RemoveStale bool
+
+	// Timeout, if non-zero, limits how long the compilation of a single
+	// file may run. Compiles that run longer fail with ErrTimeout.
+	Timeout time.Duration
+
+	// MaxConcurrentCompiles, if non-zero, limits how many files are
+	// compiled at the same time when serving.
+	MaxConcurrentCompiles int
+
+	// TscFlags are extra command line flags for tsc. They are applied on
+	// top of the compiler options in the nearest tsconfig.json.
+	TscFlags []string
+
+	// WarningLogger, if set, is called with the warnings the compilers and
+	// closure-compiler report while building src. Otherwise the warnings
+	// are written to stderr.
+	WarningLogger func(src string, warnings []Diagnostic)
+
+	// WarningsAsErrors makes a closure-compiler warning fail the compile.
+	WarningsAsErrors bool
+
+	// Workers, if non-zero, is the number of long-running sass, tsc and
+	// closure-compiler processes to keep for compiling rather than starting
+	// a new process for every file. The workers are stopped by Close.
+	Workers int
+
+	sem     chan struct{}
+	semOnce sync.Once
+	workers workerPools
 }
 
+// ErrTimeout is wrapped by the error of a compile that does not finish
+// before its deadline.
+var ErrTimeout = errors.New("compile timed out")
+
+// ErrWarnings is wrapped by the error of a compile that failed only
+// because of warnings when Config.WarningsAsErrors is set.
+var ErrWarnings = errors.New("warnings treated as errors")
+
 // NewConfig ...
 func NewConfig(level Optimization) *Config {
 	return &Config{
@@ -380,11 +490,18 @@ func NewConfig(level Optimization) *Config {
 	}
 }
 
-// Content ...
+// Content serves and productionizes the sources in d with c. Closing the
+// handler stops the compiler workers of c.
 func Content(c *Config, d ...http.Dir) Handler {
 	return &content{root: d, conf: c}
 }
 
+// Stops the workers of the handler's Config. They start again if the
+// Config is used after that, so other handlers that share it keep working.
+func (h *content) Close() error {
+	return h.conf.Close()
+}
+
 func expandPath(fs http.Dir, name string) string {
 	return filepath.Join(string(fs), filepath.FromSlash(path.Clean("/"+name)))
 }
@@ -433,12 +550,21 @@ func typeOfSrc(filename string) srcType {
 	if strings.HasSuffix(filename, tscFileExtension) {
 		return srcOfTsc
 	}
+	if strings.HasSuffix(filename, tsxFileExtension) {
+		return srcOfTsx
+	}
 	if strings.HasSuffix(filename, jsFileExtension) {
 		return srcOfJs
 	}
 	if strings.HasSuffix(filename, scssFileExtension) {
 		return srcOfScss
 	}
+	if strings.HasSuffix(filename, modulesFileExtension) {
+		return srcOfModules
+	}
+	if strings.HasSuffix(filename, listFileExtension) {
+		return srcOfList
+	}
 	return srcOfUnknown
 }
 
@@ -458,6 +584,9 @@ type Response struct {
 	srcType srcType
 	srcFile string
 	req     *http.Request
+
+	// the chunk of a .main.modules manifest that was asked for
+	chunk string
 }
 
 // Deliver ...
@@ -471,36 +600,67 @@ func (r *Response) Deliver(cfg *Config, w ResponseWriter) {
 		}
 		w.EnableCompression()
 		http.ServeFile(w, r.req, r.srcFile)
-	case srcOfJsx:
-		w.EnableCompression()
-		w.Header().Set("Content-Type", "text/javascript")
-		if err := compile(cfg, r.srcFile, w, CompileJsx, optimizeJs); err != nil {
-			panic(err)
-		}
-	case srcOfTsc:
+	case srcOfJsx, srcOfTsc, srcOfTsx, srcOfJs:
 		w.EnableCompression()
 		w.Header().Set("Content-Type", "text/javascript")
-		if err := compile(cfg, r.srcFile, w, CompileTsc, optimizeJs); err != nil {
-			panic(err)
-		}
-	case srcOfJs:
-		w.EnableCompression()
-		w.Header().Set("Content-Type", "text/javascript")
-		if err := compile(cfg, r.srcFile, w, CompileJs, optimizeJs); err != nil {
-			panic(err)
-		}
+		r.compile(cfg, w)
 	case srcOfScss:
 		w.EnableCompression()
 		w.Header().Set("Content-Type", "text/css")
-		if err := compile(cfg, r.srcFile, w, CompileScss, optimizeCss); err != nil {
-			panic(err)
+		r.compile(cfg, w)
+	case srcOfModules:
+		r.compileChunk(cfg, w)
+	case srcOfList:
+		w.EnableCompression()
+		if typeOfDst(path) == dstOfCSS {
+			w.Header().Set("Content-Type", "text/css")
+		} else {
+			w.Header().Set("Content-Type", "text/javascript")
 		}
+		r.compile(cfg, w)
 	default:
 		panic("unknown src type")
 	}
 }
 
-// FindContent ...
+// Serves one chunk of a .main.modules manifest. Every chunk is compiled
+// together, so one compile is shared by the requests for all of them.
+func (r *Response) compileChunk(cfg *Config, w ResponseWriter) {
+	chunks, err := compileChunksShared(r.req.Context(), cfg, r.srcFile)
+	if errors.Is(err, context.Canceled) {
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	for _, ch := range chunks {
+		if ch.name == r.chunk {
+			w.EnableCompression()
+			w.Header().Set("Content-Type", "text/javascript")
+			w.Write(ch.out)
+			return
+		}
+	}
+	w.ServeNotFound()
+}
+
+// Compiles the source for the request, sharing the work with concurrent
+// requests for the same source. The compilers are stopped once every
+// client waiting on them goes away, in which case there is no one to
+// report an error to.
+func (r *Response) compile(cfg *Config, w ResponseWriter) {
+	err := compileShared(r.req.Context(), cfg, r.srcFile, w)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		panic(err)
+	}
+}
+
+// FindContent finds the file that answers a request. A file that exists
+// is served as it is. Otherwise /x.js is compiled from the first of
+// x.main.jsx, x.main.tsx, x.main.ts, x.main.js and x.main.list that
+// exists, or is a chunk of a .main.modules manifest, and /x.css is
+// compiled from x.main.scss or x.main.list. A .main.list only answers for
+// the type of the files it lists.
 func FindContent(prefix string, r *http.Request, d ...http.Dir) (*Response, error) {
 	pth := r.URL.Path
 	rel, err := filepath.Rel(prefix, pth)
@@ -508,6 +668,11 @@ func FindContent(prefix string, r *http.Request, d ...http.Dir) (*Response, erro
 		return nil, err
 	}
 
+	// the manifest of a build says where its sources are
+	if filepath.Base(rel) == manifestFileName {
+		return nil, nil
+	}
+
 	// if the file exists, create a direct response
 	if target, found := findFile(d, rel); found != foundNothing {
 		return &Response{
@@ -531,6 +696,16 @@ func FindContent(prefix string, r *http.Request, d ...http.Dir) (*Response, erro
 			}, nil
 		}
 
+		tsxSrc, found := findFile(d, changeTypeOfFile(rel, javaScriptFileExtension, tsxFileExtension))
+		if found == foundFile {
+			return &Response{
+				found:   found,
+				srcType: srcOfTsx,
+				srcFile: tsxSrc,
+				req:     r,
+			}, nil
+		}
+
 		tscSrc, found := findFile(d, changeTypeOfFile(rel, javaScriptFileExtension, tscFileExtension))
 		if found == foundFile {
 			return &Response{
@@ -551,6 +726,30 @@ func FindContent(prefix string, r *http.Request, d ...http.Dir) (*Response, erro
 			}, nil
 		}
 
+		listSrc, found := findFile(d, changeTypeOfFile(rel, javaScriptFileExtension, listFileExtension))
+		if found == foundFile && listAnswers(listSrc, dstOfJs) {
+			return &Response{
+				found:   found,
+				srcType: srcOfList,
+				srcFile: listSrc,
+				req:     r,
+			}, nil
+		}
+
+		// try to answer with a chunk of a manifest
+		if manifest, chunk, ok := splitChunkPath(rel); ok {
+			modSrc, found := findFile(d, manifest)
+			if found == foundFile {
+				return &Response{
+					found:   found,
+					srcType: srcOfModules,
+					srcFile: modSrc,
+					req:     r,
+					chunk:   chunk,
+				}, nil
+			}
+		}
+
 	case dstOfCSS:
 		cssSrc, found := findFile(d, changeTypeOfFile(rel, cssFileExtension, scssFileExtension))
 		if found == foundFile {
@@ -561,6 +760,16 @@ func FindContent(prefix string, r *http.Request, d ...http.Dir) (*Response, erro
 				req:     r,
 			}, nil
 		}
+
+		listSrc, found := findFile(d, changeTypeOfFile(rel, cssFileExtension, listFileExtension))
+		if found == foundFile && listAnswers(listSrc, dstOfCSS) {
+			return &Response{
+				found:   found,
+				srcType: srcOfList,
+				srcFile: listSrc,
+				req:     r,
+			}, nil
+		}
 	}
 	return nil, nil
 }
@@ -608,39 +817,139 @@ func catFile(w io.Writer, filename string) error {
 	return nil
 }
 
-func compile(c *Config, src string, w io.Writer,
-	cmp func(*Config, string, string) error,
-	opt func(*Config, io.Writer) (io.WriteCloser, error)) error {
+// The error for work that was stopped because ctx is done.
+func contextError(ctx context.Context, name string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s: %w", name, ErrTimeout)
+	}
+	return fmt.Errorf("%s: %w", name, ctx.Err())
+}
 
-	// create an optimization pipe
-	wo, err := opt(c, w)
-	if err != nil {
-		return err
+// Compiles a source, writing the output into the writer.
+type compiler func(context.Context, *Config, string, io.Writer) error
+
+// Creates a pipe that optimizes the output compiled from a source.
+type optimizer func(context.Context, *Config, string, io.Writer) (io.WriteCloser, error)
+
+// Adapts a compiler that can only write to a file into one that streams
+// from a temp file.
+func streamFromFile(cmp func(context.Context, *Config, string, string) error) compiler {
+	return func(ctx context.Context, c *Config, src string, w io.Writer) error {
+		t, err := ioutil.TempFile(os.TempDir(), "cmp-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(t.Name())
+		t.Close()
+
+		if err := cmp(ctx, c, src, t.Name()); err != nil {
+			return err
+		}
+
+		return catFile(w, t.Name())
 	}
-	defer wo.Close()
+}
+
+func compile(ctx context.Context, c *Config, src string, w io.Writer,
+	cmp compiler, opt optimizer) error {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	err := compilePipeline(ctx, c, src, w, cmp, opt)
+	if errors.Is(err, ErrTimeout) && c.Timeout > 0 {
+		return fmt.Errorf("%s: %w after %s", src, err, c.Timeout)
+	}
+	return err
+}
+
+func compilePipeline(ctx context.Context, c *Config, src string, w io.Writer,
+	cmp compiler, opt optimizer) error {
 
-	// open a temp file for the base compilation
-	t, err := ioutil.TempFile(os.TempDir(), "cmp-")
+	// create an optimization pipe
+	wo, err := opt(ctx, c, src, w)
 	if err != nil {
 		return err
 	}
-	defer t.Close()
-	defer os.Remove(t.Name())
 
-	// TODO(knorton): This can be executed in parallel with
-	// directive expansion. It just needs to return the underlying
-	// os.Process which allows for Wait.
-	if err := cmp(c, src, t.Name()); err != nil {
+	if err := compileInto(ctx, c, src, wo, cmp); err != nil {
+		wo.Close()
 		return err
 	}
 
+	// the optimizer reports its own failures when it is closed
+	return wo.Close()
+}
+
+// Writes the expanded source directives followed by the compiler output
+// into w. The compiler runs while the directives are expanded and its
+// output is copied through as it is produced.
+func compileInto(ctx context.Context, c *Config, src string, w io.Writer,
+	cmp compiler) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		err := cmp(ctx, c, src, pw)
+		pw.CloseWithError(err)
+		errc <- err
+	}()
+
 	// expand source directives
-	if err := expandDirectives(src, wo); err != nil {
+	if err := expandDirectives(src, w); err != nil {
+		cancel()
+		pr.CloseWithError(err)
+		<-errc
 		return err
 	}
 
 	// copy the compile output into the writer
-	return catFile(wo, t.Name())
+	if _, err := io.Copy(w, pr); err != nil {
+		cancel()
+		pr.CloseWithError(err)
+		if cerr := <-errc; cerr != nil {
+			return cerr
+		}
+		return err
+	}
+
+	return <-errc
+}
+
+// CompileFile runs a single source file through the same pipeline that is
+// used to serve it and writes the result to w.
+func CompileFile(c *Config, src string, w io.Writer) error {
+	return CompileFileContext(context.Background(), c, src, w)
+}
+
+// CompileFileContext is CompileFile, but the compilers are stopped if ctx
+// is done before they finish.
+func CompileFileContext(ctx context.Context, c *Config, src string, w io.Writer) error {
+	switch typeOfSrc(src) {
+	case srcOfJsx:
+		return compile(ctx, c, src, w, streamJsx, optimizeJs)
+	case srcOfTsc, srcOfTsx:
+		return compile(ctx, c, src, w, streamTsc, optimizeJs)
+	case srcOfJs:
+		return compile(ctx, c, src, w, streamJs, optimizeJs)
+	case srcOfScss:
+		return compile(ctx, c, src, w, streamScss, optimizeCss)
+	case srcOfModules:
+		return fmt.Errorf("%s: builds one output for each chunk, not a single file", src)
+	case srcOfList:
+		l, err := parseList(src)
+		if err != nil {
+			return err
+		}
+		return compile(ctx, c, src, w, streamList, listOptimizer(l.typ))
+	}
+	return fmt.Errorf("%s: not a pork source (expected %s, %s, %s, %s, %s or %s)", src,
+		jsxFileExtension, tsxFileExtension, tscFileExtension, jsFileExtension, scssFileExtension,
+		listFileExtension)
 }
 
 func ensureDir(dir string) error {
@@ -652,9 +961,7 @@ func ensureDir(dir string) error {
 	return nil
 }
 
-func compileToFile(c *Config, src, dst string,
-	cmp func(*Config, string, string) error,
-	opt func(*Config, io.Writer) (io.WriteCloser, error)) error {
+func compileToFile(c *Config, src, dst string, cmp compiler, opt optimizer) error {
 	dir := filepath.Dir(dst)
 
 	if err := ensureDir(dir); err != nil {
@@ -667,7 +974,21 @@ func compileToFile(c *Config, src, dst string,
 	}
 	defer file.Close()
 
-	return compile(c, src, file, cmp, opt)
+	// never leave a partial output behind
+	if err := compile(context.Background(), c, src, file, cmp, opt); err != nil {
+		file.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return nil
+}
+
+func writeFile(dst string, data []byte) error {
+	if err := ensureDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, data, 0644)
 }
 
 func copyFile(dst, src string) error {
@@ -694,6 +1015,30 @@ func copyFile(dst, src string) error {
 	return nil
 }
 
+func optimizeFile(c *Config, dst, src string, opt optimizer) error {
+	if err := ensureDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	wo, err := opt(context.Background(), c, src, w)
+	if err != nil {
+		return err
+	}
+
+	if err := catFile(wo, src); err != nil {
+		wo.Close()
+		return err
+	}
+
+	return wo.Close()
+}
+
 func isExcludedSrc(path string) bool {
 	for _, suf := range excludedSrcExtensions {
 		if strings.HasSuffix(path, suf) {
@@ -703,7 +1048,51 @@ func isExcludedSrc(path string) bool {
 	return false
 }
 
-func productionize(cfg *Config, roots []http.Dir, dest http.Dir) error {
+// The outputs written by productionize, keyed by output path with the
+// source that produced each one relative to its root.
+type build struct {
+	outputs map[string]string
+
+	// the outputs written by the build that is running
+	produced map[string]bool
+}
+
+func newBuild() *build {
+	return &build{
+		outputs:  map[string]string{},
+		produced: map[string]bool{},
+	}
+}
+
+func (b *build) record(cfg *Config, root, src, dst string) {
+	rel, err := filepath.Rel(root, src)
+	if err != nil {
+		rel = src
+	}
+
+	b.outputs[dst] = filepath.ToSlash(rel)
+	b.produced[dst] = true
+	if cfg.BuildLogger != nil {
+		cfg.BuildLogger(src, dst)
+	}
+}
+
+func sameDir(a, b string) bool {
+	aa, err := filepath.Abs(a)
+	if err != nil {
+		return false
+	}
+	ab, err := filepath.Abs(b)
+	if err != nil {
+		return false
+	}
+	return aa == ab
+}
+
+// Builds every source beneath roots into dest. When affected is non-nil,
+// only the files for which it returns true are built.
+func productionize(cfg *Config, roots []http.Dir, dest http.Dir,
+	affected func(string) bool, b *build) error {
 	d := string(dest)
 	if _, err := os.Stat(d); err != nil {
 		if !os.IsNotExist(err) {
@@ -717,6 +1106,23 @@ func productionize(cfg *Config, roots []http.Dir, dest http.Dir) error {
 	for _, root := range roots {
 		src := string(root)
 		if err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// never descend into the output when it lives beneath a root
+			if info.IsDir() && path != src && sameDir(path, d) {
+				return filepath.SkipDir
+			}
+
+			if affected != nil && !affected(path) {
+				return nil
+			}
+
+			if filepath.Base(path) == manifestFileName {
+				return nil
+			}
+
 			switch typeOfSrc(path) {
 			case srcOfJsx:
 				target, err := rebasePath(src, d,
@@ -725,9 +1131,10 @@ func productionize(cfg *Config, roots []http.Dir, dest http.Dir) error {
 					return err
 				}
 
-				if err := compileToFile(cfg, path, target, CompileJsx, optimizeJs); err != nil {
+				if err := compileToFile(cfg, path, target, streamJsx, optimizeJs); err != nil {
 					return err
 				}
+				b.record(cfg, src, path, target)
 			case srcOfTsc:
 				target, err := rebasePath(src, d,
 					changeTypeOfFile(path, tscFileExtension, javaScriptFileExtension))
@@ -735,9 +1142,21 @@ func productionize(cfg *Config, roots []http.Dir, dest http.Dir) error {
 					return err
 				}
 
-				if err := compileToFile(cfg, path, target, CompileTsc, optimizeJs); err != nil {
+				if err := compileToFile(cfg, path, target, streamTsc, optimizeJs); err != nil {
+					return err
+				}
+				b.record(cfg, src, path, target)
+			case srcOfTsx:
+				target, err := rebasePath(src, d,
+					changeTypeOfFile(path, tsxFileExtension, javaScriptFileExtension))
+				if err != nil {
+					return err
+				}
+
+				if err := compileToFile(cfg, path, target, streamTsc, optimizeJs); err != nil {
 					return err
 				}
+				b.record(cfg, src, path, target)
 			case srcOfJs:
 				target, err := rebasePath(src, d,
 					changeTypeOfFile(path, jsFileExtension, javaScriptFileExtension))
@@ -745,9 +1164,10 @@ func productionize(cfg *Config, roots []http.Dir, dest http.Dir) error {
 					return err
 				}
 
-				if err := compileToFile(cfg, path, target, CompileJs, optimizeJs); err != nil {
+				if err := compileToFile(cfg, path, target, streamJs, optimizeJs); err != nil {
 					return err
 				}
+				b.record(cfg, src, path, target)
 			case srcOfScss:
 				target, err := rebasePath(src, d,
 					changeTypeOfFile(path, scssFileExtension, cssFileExtension))
@@ -755,9 +1175,42 @@ func productionize(cfg *Config, roots []http.Dir, dest http.Dir) error {
 					return err
 				}
 
-				if err := compileToFile(cfg, path, target, CompileScss, optimizeCss); err != nil {
+				if err := compileToFile(cfg, path, target, streamScss, optimizeCss); err != nil {
+					return err
+				}
+				b.record(cfg, src, path, target)
+			case srcOfModules:
+				chunks, err := compileChunks(context.Background(), cfg, path)
+				if err != nil {
+					return err
+				}
+
+				for _, ch := range chunks {
+					target, err := rebasePath(src, d, chunkPath(path, ch.name))
+					if err != nil {
+						return err
+					}
+
+					if err := writeFile(target, ch.out); err != nil {
+						return err
+					}
+					b.record(cfg, src, path, target)
+				}
+			case srcOfList:
+				l, err := readList(path)
+				if err != nil {
 					return err
 				}
+
+				target, err := rebasePath(src, d, listPath(path, l.typ))
+				if err != nil {
+					return err
+				}
+
+				if err := compileToFile(cfg, path, target, streamList, listOptimizer(l.typ)); err != nil {
+					return err
+				}
+				b.record(cfg, src, path, target)
 			default:
 				if !info.IsDir() && !isExcludedSrc(path) {
 					target, err := rebasePath(src, d, path)
@@ -765,9 +1218,23 @@ func productionize(cfg *Config, roots []http.Dir, dest http.Dir) error {
 						return err
 					}
 
+					// building in place, the file is already where it belongs
+					if target == path {
+						return nil
+					}
+
+					if typeOfDst(path) == dstOfCSS && cfg.Level != None {
+						if err := optimizeFile(cfg, target, path, optimizeCss); err != nil {
+							return err
+						}
+						b.record(cfg, src, path, target)
+						return nil
+					}
+
 					if err := copyFile(target, path); err != nil {
 						return err
 					}
+					b.record(cfg, src, path, target)
 				}
 			}
 			return nil
@@ -779,23 +1246,104 @@ func productionize(cfg *Config, roots []http.Dir, dest http.Dir) error {
 	return nil
 }
 
+// Runs productionize and then brings the manifest of outputs in d up to
+// date, removing stale outputs first if the config asks for that. A build
+// that fails may not have produced everything, so nothing is removed.
+func (h *content) build(roots []http.Dir, d http.Dir, affected func(string) bool, b *build) error {
+	b.produced = map[string]bool{}
+	err := productionize(h.conf, roots, d, affected, b)
+
+	if err == nil && h.conf.RemoveStale {
+		if err := b.removeStale(d, roots, affected); err != nil {
+			return err
+		}
+	}
+
+	if err := b.save(d); err != nil {
+		return err
+	}
+
+	return err
+}
+
+// Productionize builds all of the content into d and then serves from d
+// ahead of the original roots. The returned function rebuilds only the
+// outputs affected by changes made since the last build (or everything,
+// if the last build failed) and does nothing when there are no changes.
+// If the initial build fails, the error is returned along with a refresh
+// function that can be used to retry.
 func (h *content) Productionize(d http.Dir) (func() error, error) {
 	h.lock.Lock()
 	defer h.lock.Unlock()
 
-	if err := productionize(h.conf, h.root, d); err != nil {
+	roots := h.root
+	b := newBuild()
+	if err := b.load(d); err != nil {
+		return nil, err
+	}
+
+	// changes to include paths also require rebuilding
+	watched := append([]http.Dir{}, roots...)
+	for _, paths := range [][]string{h.conf.ScssIncludes, h.conf.JsIncludes, h.conf.JsxIncludes} {
+		for _, path := range paths {
+			watched = append(watched, http.Dir(path))
+		}
+	}
+
+	snap, err := takeSnapshot(watched, d, b)
+	if err != nil {
 		return nil, err
 	}
 
-	// prepend the dest dir to the roots
-	root := make([]http.Dir, len(h.root)+1)
-	root[0] = d
-	copy(root[1:], h.root)
-	h.root = root
+	failed := false
+	serving := false
+	serve := func() {
+		if serving {
+			return
+		}
+		serving = true
+
+		// prepend the dest dir to the roots
+		root := make([]http.Dir, len(h.root)+1)
+		root[0] = d
+		copy(root[1:], h.root)
+		h.root = root
+	}
+
+	if err = h.build(roots, d, nil, b); err != nil {
+		failed = true
+	} else {
+		serve()
+	}
 
 	return func() error {
 		h.lock.Lock()
 		defer h.lock.Unlock()
-		return productionize(h.conf, h.root, d)
-	}, nil
+
+		next, err := takeSnapshot(watched, d, b)
+		if err != nil {
+			return err
+		}
+
+		changed := snap.changes(next)
+		snap = next
+		if len(changed) == 0 {
+			return nil
+		}
+
+		// after a failure, the build may be incomplete so start over
+		var affected func(string) bool
+		if !failed {
+			affected = affectedBy(changed)
+		}
+
+		if err := h.build(roots, d, affected, b); err != nil {
+			failed = true
+			return err
+		}
+
+		failed = false
+		serve()
+		return nil
+	}, err
 }
@@ -1,12 +1,16 @@
 package pork
 
 import (
+  "context"
   "errors"
   "io/ioutil"
+  "net/http"
+  "net/http/httptest"
   "os"
   "path/filepath"
   "strings"
   "testing"
+  "time"
 )
 
 func levels() []Optimization {
@@ -84,3 +88,153 @@ func TestJs(t *testing.T) {
     filepath.Join(Root(), "tests/js/a.js"),
     nil)
 }
+
+func TestCompileTimeout(t *testing.T) {
+  dir, err := ioutil.TempDir(os.TempDir(), "pork-test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  // answers --version right away so that only the compile itself hangs
+  sass := filepath.Join(dir, "sass")
+  script := `#!/bin/sh
+if [ "$1" = --version ]; then
+  echo "1.77.8 compiled with dart2js 3.4.0"
+  exit 0
+fi
+touch "$(dirname "$0")/compiling"
+exec sleep 10
+`
+  if err := ioutil.WriteFile(sass, []byte(script), 0755); err != nil {
+    t.Fatal(err)
+  }
+
+  src := filepath.Join(dir, "a.main.scss")
+  if err := ioutil.WriteFile(src, []byte(".a { b: c; }\n"), 0644); err != nil {
+    t.Fatal(err)
+  }
+
+  defer func(path string) {
+    PathToSass = path
+  }(PathToSass)
+  PathToSass = sass
+
+  c := NewConfig(None)
+  c.Timeout = 100 * time.Millisecond
+
+  start := time.Now()
+  err = CompileFile(c, src, ioutil.Discard)
+  if !errors.Is(err, ErrTimeout) {
+    t.Fatalf("expected a timeout, got %v", err)
+  }
+
+  if time.Since(start) > 5*time.Second {
+    t.Fatalf("compile was not stopped at the deadline")
+  }
+
+  if _, err := os.Stat(filepath.Join(dir, "compiling")); err != nil {
+    t.Fatalf("expected the timeout to stop the compile, not sass --version")
+  }
+}
+
+// A stand-in for sass that copies the source to the output.
+const fakeSass = `#!/bin/sh
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --version) echo "1.77.8 compiled with dart2js 3.4.0"; exit 0;;
+    --style|-I) shift 2;;
+    --*) shift;;
+    *) break;;
+  esac
+done
+if [ $# -ge 2 ]; then cat "$1" > "$2"; else cat "$1"; fi
+`
+
+func benchmarkCompile(b *testing.B, name, source string, cmp compiler, opt optimizer) {
+  dir, err := ioutil.TempDir(os.TempDir(), "pork-bench")
+  if err != nil {
+    b.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  sass := filepath.Join(dir, "sass")
+  if err := ioutil.WriteFile(sass, []byte(fakeSass), 0755); err != nil {
+    b.Fatal(err)
+  }
+
+  defer func(path string) {
+    PathToSass = path
+  }(PathToSass)
+  PathToSass = sass
+
+  header := filepath.Join(dir, "header.txt")
+  if err := ioutil.WriteFile(header, []byte("/* header */\n"), 0644); err != nil {
+    b.Fatal(err)
+  }
+
+  src := filepath.Join(dir, name)
+  data := "//@include(\"header.txt\")\n" + strings.Repeat(source, 2000)
+  if err := ioutil.WriteFile(src, []byte(data), 0644); err != nil {
+    b.Fatal(err)
+  }
+
+  c := NewConfig(None)
+  b.SetBytes(int64(len(data)))
+  b.ResetTimer()
+  for i := 0; i < b.N; i++ {
+    if err := compile(context.Background(), c, src, ioutil.Discard, cmp, opt); err != nil {
+      b.Fatal(err)
+    }
+  }
+}
+
+func BenchmarkCompileJsStream(b *testing.B) {
+  benchmarkCompile(b, "a.main.js", "var a = function(b) { return b + 1; };\n", streamJs,
+    optimizeJs)
+}
+
+func BenchmarkCompileJsTempFile(b *testing.B) {
+  benchmarkCompile(b, "a.main.js", "var a = function(b) { return b + 1; };\n",
+    streamFromFile(CompileJsContext), optimizeJs)
+}
+
+func BenchmarkCompileScssStream(b *testing.B) {
+  benchmarkCompile(b, "a.main.scss", ".a { color: red; }\n", streamScss,
+    optimizeCss)
+}
+
+func BenchmarkCompileScssTempFile(b *testing.B) {
+  benchmarkCompile(b, "a.main.scss", ".a { color: red; }\n",
+    streamFromFile(CompileScssContext), optimizeCss)
+}
+
+// Serves a.js from a.main.js to concurrent requests, which share the
+// compiles that overlap.
+func BenchmarkServeJs(b *testing.B) {
+  dir, err := ioutil.TempDir(os.TempDir(), "pork-bench")
+  if err != nil {
+    b.Fatal(err)
+  }
+  defer os.RemoveAll(dir)
+
+  data := strings.Repeat("var a = function(b) { return b + 1; };\n", 2000)
+  if err := ioutil.WriteFile(filepath.Join(dir, "a.main.js"), []byte(data), 0644); err != nil {
+    b.Fatal(err)
+  }
+
+  r := NewRouter(nil, nil, nil)
+  r.RespondWith("/", Content(NewConfig(None), http.Dir(dir)))
+
+  b.SetBytes(int64(len(data)))
+  b.ResetTimer()
+  b.RunParallel(func(pb *testing.PB) {
+    for pb.Next() {
+      w := httptest.NewRecorder()
+      r.ServeHTTP(w, httptest.NewRequest("GET", "/a.js", nil))
+      if w.Code != http.StatusOK || w.Body.Len() != len(data) {
+        b.Fatalf("unexpected response: %d, %d bytes", w.Code, w.Body.Len())
+      }
+    }
+  })
+}
@@ -0,0 +1,767 @@
+package pork
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A macro created by a #define directive. Function-like macros have a
+// non-nil params slice.
+type macro struct {
+	params []string
+	body   string
+}
+
+// The state of a single #if/#ifdef/#ifndef block.
+type condition struct {
+	line    int
+	taking  bool
+	taken   bool
+	sawElse bool
+}
+
+// The lexical state that must be carried from one line to the next so
+// that macros are not expanded inside of comments or template literals.
+type lexState struct {
+	inComment  bool
+	inTemplate bool
+}
+
+// A C-preprocessor style expander for JavaScript sources. It understands
+// #include, #pragma once, #define, #undef, #if, #ifdef, #ifndef, #elif,
+// #else, #endif and #error.
+type preprocessor struct {
+	includes []string
+	macros   map[string]*macro
+	once     map[string]bool
+	active   map[string]bool
+}
+
+func newPreprocessor(includes []string) *preprocessor {
+	return &preprocessor{
+		includes: includes,
+		macros:   map[string]*macro{},
+		once:     map[string]bool{},
+		active:   map[string]bool{},
+	}
+}
+
+// Expands all preprocessor directives in filename into w.
+func preprocess(c *Config, filename string, w io.Writer) error {
+	return newPreprocessor(c.JsIncludes).processFile(filename, w)
+}
+
+func errorAt(filename string, line int, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", filename, line, fmt.Sprintf(format, args...))
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+// Splits a directive line (without the leading #) into its name and
+// the remaining argument text.
+func splitDirective(l string) (string, string) {
+	l = strings.TrimSpace(l)
+	i := 0
+	for i < len(l) && isIdentPart(l[i]) {
+		i++
+	}
+	return l[:i], strings.TrimSpace(l[i:])
+}
+
+// Strips a trailing line comment from directive arguments.
+func stripDirectiveComment(s string) string {
+	if i := strings.Index(s, "//"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, "/*"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func isDirective(name string) bool {
+	switch name {
+	case "include", "pragma", "define", "undef", "if", "ifdef", "ifndef",
+		"elif", "else", "endif", "error":
+		return true
+	}
+	return false
+}
+
+func (p *preprocessor) isTaking(conds []*condition) bool {
+	for _, c := range conds {
+		if !c.taking {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *preprocessor) resolveInclude(dir, arg string) (string, error) {
+	if len(arg) < 2 {
+		return "", fmt.Errorf("invalid include: %s", arg)
+	}
+
+	var name string
+	var paths []string
+	switch {
+	case arg[0] == '"' && arg[len(arg)-1] == '"':
+		name = arg[1 : len(arg)-1]
+		paths = append([]string{dir}, p.includes...)
+	case arg[0] == '<' && arg[len(arg)-1] == '>':
+		name = arg[1 : len(arg)-1]
+		paths = p.includes
+	default:
+		return "", fmt.Errorf("invalid include: %s", arg)
+	}
+
+	for _, path := range paths {
+		target := filepath.Join(path, filepath.FromSlash(name))
+		if s, err := os.Stat(target); err == nil && !s.IsDir() {
+			return target, nil
+		}
+	}
+
+	return "", fmt.Errorf("include not found: %s", name)
+}
+
+func (p *preprocessor) define(arg string) error {
+	i := 0
+	for i < len(arg) && isIdentPart(arg[i]) {
+		i++
+	}
+
+	name := arg[:i]
+	if name == "" || !isIdentStart(name[0]) {
+		return fmt.Errorf("invalid macro name: %q", arg)
+	}
+
+	m := &macro{}
+	rest := arg[i:]
+	if strings.HasPrefix(rest, "(") {
+		e := strings.Index(rest, ")")
+		if e < 0 {
+			return fmt.Errorf("missing ) in parameter list of %s", name)
+		}
+
+		m.params = []string{}
+		for _, param := range strings.Split(rest[1:e], ",") {
+			param = strings.TrimSpace(param)
+			if param == "" {
+				continue
+			}
+			m.params = append(m.params, param)
+		}
+		rest = rest[e+1:]
+	}
+
+	m.body = stripDirectiveComment(rest)
+	p.macros[name] = m
+	return nil
+}
+
+func (p *preprocessor) processFile(filename string, w io.Writer) error {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+
+	if p.once[abs] {
+		return nil
+	}
+
+	if p.active[abs] {
+		return fmt.Errorf("%s: recursive include", filename)
+	}
+	p.active[abs] = true
+	defer delete(p.active, abs)
+
+	r, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	dir := filepath.Dir(filename)
+
+	var conds []*condition
+	var lex lexState
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for ln := 1; s.Scan(); ln++ {
+		line := s.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if !lex.inComment && !lex.inTemplate && strings.HasPrefix(trimmed, "#") {
+			name, arg := splitDirective(trimmed[1:])
+			if isDirective(name) {
+				taking := p.isTaking(conds)
+				switch name {
+				case "if", "ifdef", "ifndef":
+					c := &condition{line: ln}
+					if taking {
+						v, err := p.evalCondition(name, stripDirectiveComment(arg))
+						if err != nil {
+							return errorAt(filename, ln, "%s", err)
+						}
+						c.taking, c.taken = v, v
+					} else {
+						// nested inside of a skipped block, none of the branches apply.
+						c.taken = true
+					}
+					conds = append(conds, c)
+				case "elif":
+					if len(conds) == 0 {
+						return errorAt(filename, ln, "#elif without #if")
+					}
+					c := conds[len(conds)-1]
+					if c.sawElse {
+						return errorAt(filename, ln, "#elif after #else")
+					}
+					c.taking = false
+					if !c.taken && p.isTaking(conds[:len(conds)-1]) {
+						v, err := p.evalCondition("if", stripDirectiveComment(arg))
+						if err != nil {
+							return errorAt(filename, ln, "%s", err)
+						}
+						c.taking, c.taken = v, v
+					}
+				case "else":
+					if len(conds) == 0 {
+						return errorAt(filename, ln, "#else without #if")
+					}
+					c := conds[len(conds)-1]
+					if c.sawElse {
+						return errorAt(filename, ln, "duplicate #else")
+					}
+					c.sawElse = true
+					c.taking = !c.taken
+					c.taken = true
+				case "endif":
+					if len(conds) == 0 {
+						return errorAt(filename, ln, "#endif without #if")
+					}
+					conds = conds[:len(conds)-1]
+				default:
+					if !taking {
+						continue
+					}
+					if err := p.execDirective(filename, dir, name, arg, w); err != nil {
+						if _, ok := err.(*includeError); ok {
+							return err
+						}
+						return errorAt(filename, ln, "%s", err)
+					}
+				}
+				continue
+			}
+		}
+
+		if !p.isTaking(conds) {
+			continue
+		}
+
+		if _, err := io.WriteString(w, p.expandLine(line, &lex, nil)+"\n"); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	if len(conds) > 0 {
+		return errorAt(filename, conds[len(conds)-1].line, "unterminated conditional")
+	}
+
+	return nil
+}
+
+// Errors that occur inside of an included file already carry their own
+// location, so they are passed through untouched.
+type includeError struct {
+	error
+}
+
+func (p *preprocessor) execDirective(filename, dir, name, arg string, w io.Writer) error {
+	switch name {
+	case "include":
+		target, err := p.resolveInclude(dir, stripDirectiveComment(arg))
+		if err != nil {
+			return err
+		}
+		if err := p.processFile(target, w); err != nil {
+			return &includeError{err}
+		}
+	case "pragma":
+		if stripDirectiveComment(arg) == "once" {
+			abs, err := filepath.Abs(filename)
+			if err != nil {
+				return err
+			}
+			p.once[abs] = true
+		}
+	case "define":
+		return p.define(arg)
+	case "undef":
+		delete(p.macros, stripDirectiveComment(arg))
+	case "error":
+		return fmt.Errorf("#error %s", arg)
+	}
+	return nil
+}
+
+func (p *preprocessor) evalCondition(kind, arg string) (bool, error) {
+	switch kind {
+	case "ifdef":
+		_, ok := p.macros[arg]
+		return ok, nil
+	case "ifndef":
+		_, ok := p.macros[arg]
+		return !ok, nil
+	}
+
+	e := &condExpr{p: p, toks: tokenizeCondition(arg)}
+	v, err := e.parseOr()
+	if err != nil {
+		return false, err
+	}
+	if e.pos < len(e.toks) {
+		return false, fmt.Errorf("unexpected %q in #if", e.toks[e.pos])
+	}
+	return v != 0, nil
+}
+
+func tokenizeCondition(s string) []string {
+	var toks []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case isIdentPart(c):
+			j := i
+			for j < len(s) && isIdentPart(s[j]) {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		case i+1 < len(s) && (s[i:i+2] == "&&" || s[i:i+2] == "||" ||
+			s[i:i+2] == "==" || s[i:i+2] == "!="):
+			toks = append(toks, s[i:i+2])
+			i += 2
+		default:
+			toks = append(toks, s[i:i+1])
+			i++
+		}
+	}
+	return toks
+}
+
+// A tiny recursive descent evaluator for #if expressions.
+type condExpr struct {
+	p    *preprocessor
+	toks []string
+	pos  int
+}
+
+func (e *condExpr) peek() string {
+	if e.pos < len(e.toks) {
+		return e.toks[e.pos]
+	}
+	return ""
+}
+
+func (e *condExpr) next() string {
+	t := e.peek()
+	e.pos++
+	return t
+}
+
+func (e *condExpr) parseOr() (int64, error) {
+	l, err := e.parseAnd()
+	if err != nil {
+		return 0, err
+	}
+	for e.peek() == "||" {
+		e.next()
+		r, err := e.parseAnd()
+		if err != nil {
+			return 0, err
+		}
+		l = boolToInt(l != 0 || r != 0)
+	}
+	return l, nil
+}
+
+func (e *condExpr) parseAnd() (int64, error) {
+	l, err := e.parseEquality()
+	if err != nil {
+		return 0, err
+	}
+	for e.peek() == "&&" {
+		e.next()
+		r, err := e.parseEquality()
+		if err != nil {
+			return 0, err
+		}
+		l = boolToInt(l != 0 && r != 0)
+	}
+	return l, nil
+}
+
+func (e *condExpr) parseEquality() (int64, error) {
+	l, err := e.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	for e.peek() == "==" || e.peek() == "!=" {
+		op := e.next()
+		r, err := e.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		l = boolToInt((l == r) == (op == "=="))
+	}
+	return l, nil
+}
+
+func (e *condExpr) parseUnary() (int64, error) {
+	t := e.next()
+	switch {
+	case t == "":
+		return 0, fmt.Errorf("unexpected end of #if expression")
+	case t == "!":
+		v, err := e.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		return boolToInt(v == 0), nil
+	case t == "(":
+		v, err := e.parseOr()
+		if err != nil {
+			return 0, err
+		}
+		if e.next() != ")" {
+			return 0, fmt.Errorf("missing ) in #if expression")
+		}
+		return v, nil
+	case t == "defined":
+		paren := e.peek() == "("
+		if paren {
+			e.next()
+		}
+		name := e.next()
+		if paren && e.next() != ")" {
+			return 0, fmt.Errorf("missing ) after defined")
+		}
+		_, ok := e.p.macros[name]
+		return boolToInt(ok), nil
+	case t[0] >= '0' && t[0] <= '9':
+		v, err := strconv.ParseInt(t, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number in #if: %s", t)
+		}
+		return v, nil
+	case isIdentStart(t[0]):
+		// undefined identifiers and non-numeric macros evaluate to 0
+		if m, ok := e.p.macros[t]; ok && m.params == nil {
+			if v, err := strconv.ParseInt(m.body, 0, 64); err == nil {
+				return v, nil
+			}
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unexpected %q in #if", t)
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Quotes s as a JavaScript string literal for the # operator.
+func stringifyArg(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString("\\n")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Splits the arguments of a function-like macro invocation. s must begin
+// with '('. Returns the arguments and the number of bytes consumed or -1
+// if the invocation is not closed.
+func splitMacroArgs(s string) ([]string, int) {
+	var args []string
+	depth, start := 0, 1
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				if len(args) == 1 && args[0] == "" {
+					args = nil
+				}
+				return args, i + 1
+			}
+		case ',':
+			if depth == 1 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		case '"', '\'', '`':
+			for i++; i < len(s) && s[i] != c; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+		}
+	}
+	return nil, -1
+}
+
+// Substitutes the parameters of a function-like macro into its body.
+func (m *macro) substitute(args []string) string {
+	if len(m.params) == 0 {
+		return m.body
+	}
+
+	vals := map[string]string{}
+	for i, param := range m.params {
+		if i < len(args) {
+			vals[param] = args[i]
+		} else {
+			vals[param] = ""
+		}
+	}
+
+	var b strings.Builder
+	body := m.body
+	for i := 0; i < len(body); {
+		c := body[i]
+		switch {
+		case c == '#' && i+1 < len(body) && isIdentStart(body[i+1]):
+			j := i + 1
+			for j < len(body) && isIdentPart(body[j]) {
+				j++
+			}
+			if v, ok := vals[body[i+1:j]]; ok {
+				b.WriteString(stringifyArg(v))
+			} else {
+				b.WriteString(body[i:j])
+			}
+			i = j
+		case isIdentStart(c):
+			j := i
+			for j < len(body) && isIdentPart(body[j]) {
+				j++
+			}
+			if v, ok := vals[body[i:j]]; ok {
+				b.WriteString(v)
+			} else {
+				b.WriteString(body[i:j])
+			}
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(body) && body[j] != c {
+				if body[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(body) {
+				j++
+			}
+			b.WriteString(body[i:j])
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// Expands all macros in a line of source, skipping comments, strings and
+// template literals. disabled holds macros that are currently being
+// expanded to prevent infinite recursion.
+func (p *preprocessor) expandLine(line string, lex *lexState, disabled map[string]bool) string {
+	if len(p.macros) == 0 && !lex.inComment && !lex.inTemplate {
+		// still need to track comment state for subsequent directives
+		p.scanLexState(line, lex)
+		return line
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(line); {
+		if lex.inComment {
+			e := strings.Index(line[i:], "*/")
+			if e < 0 {
+				b.WriteString(line[i:])
+				return b.String()
+			}
+			b.WriteString(line[i : i+e+2])
+			i += e + 2
+			lex.inComment = false
+			continue
+		}
+
+		if lex.inTemplate {
+			j := i
+			for j < len(line) && line[j] != '`' {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(line) {
+				b.WriteString(line[i:])
+				return b.String()
+			}
+			b.WriteString(line[i : j+1])
+			i = j + 1
+			lex.inTemplate = false
+			continue
+		}
+
+		c := line[i]
+		switch {
+		case strings.HasPrefix(line[i:], "//"):
+			b.WriteString(line[i:])
+			return b.String()
+		case strings.HasPrefix(line[i:], "/*"):
+			b.WriteString("/*")
+			i += 2
+			lex.inComment = true
+		case c == '`':
+			b.WriteByte(c)
+			i++
+			lex.inTemplate = true
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(line) && line[j] != c {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(line) {
+				j++
+			}
+			if j > len(line) {
+				j = len(line)
+			}
+			b.WriteString(line[i:j])
+			i = j
+		case isIdentStart(c):
+			j := i
+			for j < len(line) && isIdentPart(line[j]) {
+				j++
+			}
+			name := line[i:j]
+			m, ok := p.macros[name]
+			if !ok || disabled[name] {
+				b.WriteString(name)
+				i = j
+				continue
+			}
+
+			var out string
+			if m.params == nil {
+				out = m.body
+			} else {
+				k := j
+				for k < len(line) && (line[k] == ' ' || line[k] == '\t') {
+					k++
+				}
+				if k >= len(line) || line[k] != '(' {
+					// a function-like macro name without arguments is not expanded
+					b.WriteString(name)
+					i = j
+					continue
+				}
+				args, n := splitMacroArgs(line[k:])
+				if n < 0 {
+					b.WriteString(name)
+					i = j
+					continue
+				}
+				out = m.substitute(args)
+				j = k + n
+			}
+
+			dis := map[string]bool{name: true}
+			for k := range disabled {
+				dis[k] = true
+			}
+			b.WriteString(p.expandLine(out, &lexState{}, dis))
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// Advances the lexical state across a line without expanding anything.
+func (p *preprocessor) scanLexState(line string, lex *lexState) {
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case lex.inComment:
+			if strings.HasPrefix(line[i:], "*/") {
+				lex.inComment = false
+				i++
+			}
+		case lex.inTemplate:
+			if c == '\\' {
+				i++
+			} else if c == '`' {
+				lex.inTemplate = false
+			}
+		case strings.HasPrefix(line[i:], "//"):
+			return
+		case strings.HasPrefix(line[i:], "/*"):
+			lex.inComment = true
+			i++
+		case c == '`':
+			lex.inTemplate = true
+		case c == '"' || c == '\'':
+			for i++; i < len(line) && line[i] != c; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+		}
+	}
+}
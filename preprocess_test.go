@@ -0,0 +1,107 @@
+package pork
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir(os.TempDir(), "pork-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := ensureDir(filepath.Dir(path)); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestPreprocess(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.main.js": strings.Join([]string{
+			`#include "lib/b.js"`,
+			`#include "lib/b.js"`,
+			`#include "lib/c.js"`,
+			`#include "lib/c.js"`,
+			`#ifdef DEBUG`,
+			`ASSERT(x == 1);`,
+			`#else`,
+			`no_debug();`,
+			`#endif`,
+			`var s = "VERSION"; // VERSION`,
+			`var v = VERSION;`,
+		}, "\n"),
+		"lib/b.js": strings.Join([]string{
+			`#pragma once`,
+			`#define DEBUG`,
+			`#define VERSION 42`,
+			`#define ASSERT(COND) console.assert(COND, #COND)`,
+			`b();`,
+		}, "\n"),
+		"lib/c.js": strings.Join([]string{
+			`#ifndef C_JS`,
+			`#define C_JS`,
+			`c();`,
+			`#endif // C_JS`,
+		}, "\n"),
+	})
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	if err := preprocess(NewConfig(None), filepath.Join(dir, "a.main.js"), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		`b();`,
+		`c();`,
+		`console.assert(x == 1, "x == 1");`,
+		`var s = "VERSION"; // VERSION`,
+		`var v = 42;`,
+		``,
+	}, "\n")
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestPreprocessErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"missing.main.js":      "a();\n#include \"nope.js\"\n",
+		"unterminated.main.js": "#ifdef A\n\na();\n",
+		"nested.main.js":       "#include \"lib/bad.js\"\n",
+		"lib/bad.js":           "\n\n#endif\n",
+	})
+	defer os.RemoveAll(dir)
+
+	tests := map[string]string{
+		"missing.main.js":      "missing.main.js:2: include not found: nope.js",
+		"unterminated.main.js": "unterminated.main.js:1: unterminated conditional",
+		"nested.main.js":       "bad.js:3: #endif without #if",
+	}
+
+	for name, expected := range tests {
+		var buf bytes.Buffer
+		err := preprocess(NewConfig(None), filepath.Join(dir, name), &buf)
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+			continue
+		}
+
+		if !strings.HasSuffix(err.Error(), expected) {
+			t.Errorf("%s: expected error ending in %q, got %q", name, expected, err.Error())
+		}
+	}
+}
@@ -1,38 +1,562 @@
 package pork
 
 import (
+  "bytes"
+  "context"
+  "encoding/base64"
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
   "os"
   "os/exec"
   "path/filepath"
+  "regexp"
+  "strings"
+  "sync"
+  "unicode"
 )
 
-func pathToPluginsFile() string {
-  return filepath.Join(rootDir, "sass_plugins.rb")
+// The implementations of sass that pork knows how to drive.
+type sassFlavor int
+
+const (
+  // The original sass gem, which takes --no-cache and --trace.
+  rubySass sassFlavor = iota
+
+  // Dart sass (the sass package on npm or the standalone release).
+  dartSass
+)
+
+func (f sassFlavor) String() string {
+  if f == dartSass {
+    return "Dart Sass"
+  }
+  return "Ruby Sass"
+}
+
+// Dart sass reports a bare version number (1.77.8 compiled with
+// dart2js 3.4.0) while Ruby sass reports "Ruby Sass 3.7.4" or, in older
+// releases, "Sass 3.4.22 (Selective Steve)".
+func parseSassFlavor(version string) sassFlavor {
+  version = strings.TrimSpace(version)
+  if version != "" && unicode.IsDigit(rune(version[0])) {
+    return dartSass
+  }
+  return rubySass
 }
 
-func sassCommand(c *Config, src, dst string) *exec.Cmd {
-  args := []string{
-    "--require", pathToPluginsFile(),
-    "--no-cache",
-    "--trace",
+// The flavor of each sass that has been run, by path.
+var sassFlavors struct {
+  lock   sync.Mutex
+  byPath map[string]sassFlavor
+}
+
+// Finds out which implementation PathToSass is by asking for its version.
+// The answer is remembered for as long as PathToSass does not change.
+func detectSassFlavor(ctx context.Context) (sassFlavor, error) {
+  path := PathToSass
+
+  sassFlavors.lock.Lock()
+  f, ok := sassFlavors.byPath[path]
+  sassFlavors.lock.Unlock()
+  if ok {
+    return f, nil
+  }
+
+  out, err := exec.CommandContext(ctx, path, "--version").Output()
+  if err != nil {
+    if ctx.Err() != nil {
+      return rubySass, contextError(ctx, "sass")
+    }
+    return rubySass, fmt.Errorf("sass: %s", err)
   }
+  f = parseSassFlavor(string(out))
 
-  switch c.Level {
-  case Basic, Advanced:
-    args = append(args, "--style", "compressed")
+  sassFlavors.lock.Lock()
+  if sassFlavors.byPath == nil {
+    sassFlavors.byPath = map[string]sassFlavor{}
   }
+  sassFlavors.byPath[path] = f
+  sassFlavors.lock.Unlock()
+  return f, nil
+}
 
-  for _, v := range c.ScssIncludes {
-    args = append(args, "-I", v)
+// Creates the sass command that compiles src with the Sass files in
+// includes on its load path.
+func sassCommand(ctx context.Context, c *Config, flavor sassFlavor, src, dst string,
+  includes []string) *exec.Cmd {
+  compressed := c.Level == Basic || c.Level == Advanced
+
+  var args []string
+  switch flavor {
+  case dartSass:
+    args = append(args, "--no-source-map")
+    if compressed {
+      args = append(args, "--style=compressed")
+    }
+    for _, v := range includes {
+      args = append(args, "--load-path="+v)
+    }
+  default:
+    args = append(args, "--no-cache", "--trace")
+    if compressed {
+      args = append(args, "--style", "compressed")
+    }
+    for _, v := range includes {
+      args = append(args, "-I", v)
+    }
   }
 
-  args = append(args, src, dst)
-  cm := exec.Command(PathToSass, args...)
-  cm.Stderr = os.Stderr
-  cm.Stdout = os.Stdout
-  return cm
+  // without a dst, sass writes to stdout
+  args = append(args, src)
+  if dst != "" {
+    args = append(args, dst)
+  }
+  return exec.CommandContext(ctx, PathToSass, args...)
 }
 
 func CompileScss(c *Config, src, dst string) error {
-  return sassCommand(c, src, dst).Run()
+  return CompileScssContext(context.Background(), c, src, dst)
+}
+
+// CompileScssContext compiles src into dst with sass, which is killed if
+// ctx is done before it finishes.
+func CompileScssContext(ctx context.Context, c *Config, src, dst string) error {
+  flavor, err := detectSassFlavor(ctx)
+  if err != nil {
+    return err
+  }
+
+  in, err := sassInputFor(c, src)
+  if err != nil {
+    return err
+  }
+  defer in.Close()
+
+  cm := sassCommand(ctx, c, flavor, in.src, dst, in.includes)
+  if err := runCompiler(ctx, c, "sass", src, cm, parseSassDiagnostics); err != nil {
+    return in.mapError(err)
+  }
+
+  css, err := ioutil.ReadFile(dst)
+  if err != nil {
+    return err
+  }
+
+  res, err := expandDataURIs(c, src, css)
+  if err != nil || bytes.Equal(res, css) {
+    return err
+  }
+  return ioutil.WriteFile(dst, res, 0644)
+}
+
+// Compiles src with sass, streaming the output into w.
+func streamScss(ctx context.Context, c *Config, src string, w io.Writer) error {
+  flavor, err := detectSassFlavor(ctx)
+  if err != nil {
+    return err
+  }
+
+  in, err := sassInputFor(c, src)
+  if err != nil {
+    return err
+  }
+  defer in.Close()
+
+  // datauri() can only be expanded once all of the output is in
+  var buf bytes.Buffer
+
+  // the worker runs the sass gem, so there is none for Dart sass
+  if c.Workers > 0 && flavor == rubySass {
+    req := &workerRequest{Src: in.src, LoadPaths: in.includes}
+    switch c.Level {
+    case Basic, Advanced:
+      req.Style = "compressed"
+    }
+    if err := c.workerPools().sass.compile(ctx, c, req, &buf, parseSassDiagnostics); err != nil {
+      return in.mapError(err)
+    }
+  } else {
+    cm := sassCommand(ctx, c, flavor, in.src, "", in.includes)
+    cm.Stdout = &buf
+    if err := runCompiler(ctx, c, "sass", src, cm, parseSassDiagnostics); err != nil {
+      return in.mapError(err)
+    }
+  }
+
+  css, err := expandDataURIs(c, src, buf.Bytes())
+  if err != nil {
+    return err
+  }
+
+  _, err = w.Write(css)
+  return err
+}
+
+// Sass leaves calls to functions it does not know alone, so datauri("a.png")
+// arrives in the output as written.
+var cssDataURIPattern = regexp.MustCompile(`datauri\(\s*(?:"([^"]*)"|'([^']*)'|([^"')\s]+))\s*\)`)
+
+var dataURIMimeTypes = map[string]string{
+  ".svg":   "image/svg+xml",
+  ".png":   "image/png",
+  ".gif":   "image/gif",
+  ".jpg":   "image/jpeg",
+  ".jpeg":  "image/jpeg",
+  ".webp":  "image/webp",
+  ".ico":   "image/x-icon",
+  ".woff":  "font/woff",
+  ".woff2": "font/woff2",
+}
+
+// Returned (wrapped in a CompileError) when a datauri() cannot be expanded.
+var errDataURI = errors.New("datauri failed")
+
+func dataURIMimeType(name string) string {
+  if t, ok := dataURIMimeTypes[strings.ToLower(filepath.Ext(name))]; ok {
+    return t
+  }
+  return "application/octet-stream"
+}
+
+// Finds the file for a datauri() target in a Sass file in dir. Targets
+// are relative to the file that holds the call and then to the
+// ScssIncludes. An absolute target, as every target in the copy made by
+// sassInputFor is, is first tried as it is.
+func resolveDataURI(c *Config, dir, name string) string {
+  if path := filepath.FromSlash(name); filepath.IsAbs(path) {
+    if s, err := os.Stat(path); err == nil && !s.IsDir() {
+      return path
+    }
+  }
+
+  for _, d := range append([]string{dir}, c.ScssIncludes...) {
+    path := filepath.Join(d, filepath.FromSlash(name))
+    if s, err := os.Stat(path); err == nil && !s.IsDir() {
+      return path
+    }
+  }
+  return ""
+}
+
+// Whether a datauri() target is a variable or an interpolation.
+func isComputedDataURI(name string) bool {
+  return strings.Contains(name, "$") || strings.Contains(name, "#{")
+}
+
+// A datauri() call in one of the Sass files that make up a source. Path
+// is empty when the target could not be found.
+type dataURIRef struct {
+  file string
+  path string
+}
+
+// Finds src and every file it imports, which sass may read while
+// compiling it. Plain .css files are imported by Dart sass as well.
+func sassSources(c *Config, src string) []string {
+  var files []string
+
+  seen := map[string]bool{}
+  var visit func(filename string)
+  visit = func(filename string) {
+    if seen[filename] {
+      return
+    }
+    seen[filename] = true
+    files = append(files, filename)
+
+    deps, _, err := sassDeps(c, filename, nil)
+    if err != nil {
+      return
+    }
+    for _, dep := range deps {
+      if depKindOf(dep) == depOfScss || filepath.Ext(dep) == cssFileExtension {
+        visit(dep)
+      }
+    }
+  }
+  visit(src)
+
+  return files
+}
+
+// Finds the datauri() calls in src and in every Sass file it imports,
+// keyed by the target as written. Calls that take a variable or an
+// interpolation are left out since their targets are only known once
+// sass has run.
+func findDataURIs(c *Config, src string) map[string][]dataURIRef {
+  refs := map[string][]dataURIRef{}
+
+  for _, filename := range sassSources(c, src) {
+    text, err := ioutil.ReadFile(filename)
+    if err != nil {
+      continue
+    }
+
+    for _, m := range sassDataURIPattern.FindAllStringSubmatch(stripSassComments(string(text)), -1) {
+      name := strings.TrimSpace(m[1])
+      if isComputedDataURI(name) {
+        continue
+      }
+      refs[name] = append(refs[name], dataURIRef{
+        file: filename,
+        path: resolveDataURI(c, filepath.Dir(filename), name),
+      })
+    }
+  }
+
+  return refs
+}
+
+// Whether a datauri() target names different files in different Sass
+// files.
+func hasAmbiguousDataURIs(refs map[string][]dataURIRef) bool {
+  for _, r := range refs {
+    path := ""
+    for _, ref := range r {
+      if ref.path == "" {
+        continue
+      }
+      if path != "" && ref.path != path {
+        return true
+      }
+      path = ref.path
+    }
+  }
+  return false
+}
+
+// What sass is run on for a source.
+type sassInput struct {
+  src      string
+  includes []string
+
+  // the temp directory holding a copy of the sources, if there is one
+  copy string
+}
+
+// Decides what sass is run on for src. Its output no longer says which
+// file each datauri() call came from, so when a target names different
+// files in different Sass files, sass is run on a copy of them in which
+// every target that can be found is replaced by the absolute path of its
+// file. The copy of a file is at its absolute path inside a temp
+// directory, so imports find the same files they would have.
+func sassInputFor(c *Config, src string) (*sassInput, error) {
+  in := &sassInput{src: src, includes: c.ScssIncludes}
+  if !hasAmbiguousDataURIs(findDataURIs(c, src)) {
+    return in, nil
+  }
+
+  dir, err := ioutil.TempDir(os.TempDir(), "pork-sass")
+  if err != nil {
+    return nil, err
+  }
+  in.copy = dir
+
+  copyPath := func(path string) (string, error) {
+    abs, err := filepath.Abs(path)
+    if err != nil {
+      return "", err
+    }
+    return filepath.Join(dir, abs[len(filepath.VolumeName(abs)):]), nil
+  }
+
+  for _, file := range sassSources(c, src) {
+    text, err := ioutil.ReadFile(file)
+    if err != nil {
+      in.Close()
+      return nil, err
+    }
+
+    dst, err := copyPath(file)
+    if err != nil {
+      in.Close()
+      return nil, err
+    }
+
+    if err := ensureDir(filepath.Dir(dst)); err != nil {
+      in.Close()
+      return nil, err
+    }
+
+    text = []byte(absDataURIs(c, filepath.Dir(file), string(text)))
+    if err := ioutil.WriteFile(dst, text, 0644); err != nil {
+      in.Close()
+      return nil, err
+    }
+  }
+
+  if in.src, err = copyPath(src); err != nil {
+    in.Close()
+    return nil, err
+  }
+
+  in.includes = nil
+  for _, include := range c.ScssIncludes {
+    path, err := copyPath(include)
+    if err != nil {
+      in.Close()
+      return nil, err
+    }
+    in.includes = append(in.includes, path)
+  }
+  return in, nil
+}
+
+// Replaces each datauri() target in text, a Sass file in dir, that can be
+// found with the absolute path of its file.
+func absDataURIs(c *Config, dir, text string) string {
+  return sassDataURIPattern.ReplaceAllStringFunc(text, func(call string) string {
+    name := strings.TrimSpace(sassDataURIPattern.FindStringSubmatch(call)[1])
+    if isComputedDataURI(name) {
+      return call
+    }
+
+    path := resolveDataURI(c, dir, name)
+    if path == "" {
+      return call
+    }
+
+    abs, err := filepath.Abs(path)
+    if err != nil {
+      return call
+    }
+    return fmt.Sprintf("datauri(%q)", filepath.ToSlash(abs))
+  })
+}
+
+// Removes the copy of the sources, if there is one.
+func (in *sassInput) Close() error {
+  if in.copy == "" {
+    return nil
+  }
+  return os.RemoveAll(in.copy)
+}
+
+// Makes the diagnostics in an error from sass refer to the sources rather
+// than to their copy.
+func (in *sassInput) mapError(err error) error {
+  ce, ok := err.(*CompileError)
+  if !ok || in.copy == "" {
+    return err
+  }
+
+  unmap := func(s string) string {
+    return strings.Replace(s, in.copy, "", -1)
+  }
+
+  for i := range ce.Diagnostics {
+    d := &ce.Diagnostics[i]
+    if abs, err := filepath.Abs(d.File); err == nil && strings.HasPrefix(abs, in.copy) {
+      d.File = abs
+    }
+    d.File = unmap(d.File)
+    d.Message = unmap(d.Message)
+  }
+  ce.Output = unmap(ce.Output)
+  return ce
+}
+
+// Decides which file a datauri() target in the output of src refers to.
+// The output no longer says which file each call came from, so a target
+// that names different files in different sources is an error rather
+// than a guess. Sass is given absolute targets in that case (see
+// sassInputFor), so this only happens to output that sass produced from
+// the sources themselves.
+func dataURIPath(c *Config, src, name string, refs []dataURIRef) (string, *Diagnostic) {
+  // the target was computed, so the best we know is the source itself
+  if len(refs) == 0 {
+    refs = []dataURIRef{{
+      file: src,
+      path: resolveDataURI(c, filepath.Dir(src), name),
+    }}
+  }
+
+  var paths, files []string
+  for _, ref := range refs {
+    if ref.path == "" {
+      return "", &Diagnostic{
+        File:     ref.file,
+        Severity: SeverityError,
+        Message:  fmt.Sprintf("datauri(%q): file not found", name),
+      }
+    }
+
+    found := false
+    for _, path := range paths {
+      found = found || path == ref.path
+    }
+    if !found {
+      paths = append(paths, ref.path)
+      files = append(files, ref.file)
+    }
+  }
+
+  if len(paths) > 1 {
+    var choices []string
+    for i, path := range paths {
+      choices = append(choices, fmt.Sprintf("%s in %s", path, files[i]))
+    }
+    return "", &Diagnostic{
+      File:     src,
+      Severity: SeverityError,
+      Message: fmt.Sprintf("datauri(%q) is ambiguous: %s", name,
+        strings.Join(choices, ", ")),
+    }
+  }
+  return paths[0], nil
+}
+
+// Replaces each datauri() call in the css compiled from src with a url()
+// that embeds the file as base64.
+func expandDataURIs(c *Config, src string, css []byte) ([]byte, error) {
+  matches := cssDataURIPattern.FindAllSubmatchIndex(css, -1)
+  if len(matches) == 0 {
+    return css, nil
+  }
+
+  refs := findDataURIs(c, src)
+
+  var buf bytes.Buffer
+  var diags []Diagnostic
+  last := 0
+  for _, m := range matches {
+    var name string
+    for i := 2; i < len(m); i += 2 {
+      if m[i] >= 0 {
+        name = string(css[m[i]:m[i+1]])
+        break
+      }
+    }
+
+    path, diag := dataURIPath(c, src, name, refs[name])
+    if diag != nil {
+      diags = append(diags, *diag)
+      continue
+    }
+
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+      return nil, err
+    }
+
+    buf.Write(css[last:m[0]])
+    fmt.Fprintf(&buf, "url(\"data:%s;base64,%s\")",
+      dataURIMimeType(name),
+      base64.StdEncoding.EncodeToString(data))
+    last = m[1]
+  }
+
+  if len(diags) > 0 {
+    return nil, &CompileError{
+      Tool:        "sass",
+      Src:         src,
+      Diagnostics: diags,
+      Err:         errDataURI,
+    }
+  }
+
+  buf.Write(css[last:])
+  return buf.Bytes(), nil
 }
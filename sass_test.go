@@ -0,0 +1,211 @@
+package pork
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSassFlavor(t *testing.T) {
+	tests := map[string]sassFlavor{
+		"1.77.8 compiled with dart2js 3.4.0\n": dartSass,
+		"1.32.0\n":                             dartSass,
+		"Ruby Sass 3.7.4\n":                    rubySass,
+		"Sass 3.4.22 (Selective Steve)\n":      rubySass,
+	}
+	for version, expected := range tests {
+		if actual := parseSassFlavor(version); actual != expected {
+			t.Errorf("%q: expected %s, got %s", version, expected, actual)
+		}
+	}
+}
+
+func TestSassCommand(t *testing.T) {
+	c := NewConfig(Basic)
+	c.ScssIncludes = []string{"inc"}
+
+	tests := map[sassFlavor][]string{
+		rubySass: {"--no-cache", "--trace", "--style", "compressed", "-I", "inc", "a.scss"},
+		dartSass: {"--no-source-map", "--style=compressed", "--load-path=inc", "a.scss"},
+	}
+	for flavor, expected := range tests {
+		cm := sassCommand(context.Background(), c, flavor, "a.scss", "", c.ScssIncludes)
+		if actual := cm.Args[1:]; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: expected %v, got %v", flavor, expected, actual)
+		}
+	}
+}
+
+func TestExpandDataURIs(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"css/a.main.scss":   "@import \"lib/b\";\n.a { b: datauri(\"../img/a.png\"); }\n",
+		"css/lib/_b.scss":   ".b { c: datauri('b.svg'); d: datauri('e.ico'); }\n",
+		"css/lib/b.svg":     "<svg/>",
+		"css/lib/e.ico":     "lib ico",
+		"img/a.png":         "png",
+		"inc/c.gif":         "gif",
+		"inc/e.ico":         "inc ico",
+		"css/missing.scss":  ".a { b: datauri(\"nope.png\"); }\n",
+		"css/both.scss":     "@import \"lib/b\";\n.c { d: datauri(\"e.ico\"); }\n",
+		"css/variable.scss": "$i: \"f.webp\";\n.a { b: datauri($i); }\n",
+		"css/f.webp":        "webp",
+	})
+	defer os.RemoveAll(dir)
+
+	c := NewConfig(None)
+	c.ScssIncludes = []string{filepath.Join(dir, "inc")}
+
+	path := func(name string) string {
+		return filepath.Join(dir, filepath.FromSlash(name))
+	}
+
+	encode := func(mime, data string) string {
+		return "url(\"data:" + mime + ";base64," + base64.StdEncoding.EncodeToString([]byte(data)) + "\")"
+	}
+
+	tests := []struct {
+		src      string
+		css      string
+		expected string
+	}{
+		// e.ico is next to _b.scss, which wins over the ScssIncludes
+		{"css/a.main.scss",
+			`.a{b:datauri("../img/a.png")}.b{c:datauri('b.svg');d:datauri("e.ico")}.c{d:datauri(c.gif)}`,
+			".a{b:" + encode("image/png", "png") +
+				"}.b{c:" + encode("image/svg+xml", "<svg/>") +
+				";d:" + encode("image/x-icon", "lib ico") +
+				"}.c{d:" + encode("image/gif", "gif") + "}"},
+		{"css/variable.scss",
+			`.a{b:datauri("f.webp")}`,
+			".a{b:" + encode("image/webp", "webp") + "}"},
+	}
+	for _, test := range tests {
+		res, err := expandDataURIs(c, path(test.src), []byte(test.css))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(res) != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.src, test.expected, res)
+		}
+	}
+
+	errs := map[string]string{
+		"css/missing.scss": `.a{b:datauri("nope.png")}`,
+		"css/both.scss":    `.b{c:datauri("e.ico")}.c{d:datauri("e.ico")}`,
+	}
+	for src, css := range errs {
+		_, err := expandDataURIs(c, path(src), []byte(css))
+		if !errors.Is(err, errDataURI) {
+			t.Errorf("%s: expected a datauri error, got %v", src, err)
+		}
+	}
+}
+
+// Stands in for sass on a source that imports lib/b, which it inlines after
+// the source.
+const fakeSassWithImport = `#!/bin/sh
+if [ "$1" = --version ]; then echo "1.77.8 compiled with dart2js 3.4.0"; exit 0; fi
+for a; do src="$a"; done
+if grep -q @error "$src"; then echo "$src:2: broken (Sass::SyntaxError)" >&2; exit 1; fi
+cat "$src" "$(dirname "$src")/lib/_b.scss"
+`
+
+// A target that names different files in different Sass files is
+// expanded to the file next to each call.
+func TestScssAmbiguousDataURIs(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"sass":            fakeSassWithImport,
+		"css/a.scss":      "@import \"lib/b\";\n.a { b: datauri(\"e.ico\"); }\n",
+		"css/broken.scss": "@import \"lib/b\";\n@error \"no\";\n.a { b: datauri(\"e.ico\"); }\n",
+		"css/lib/_b.scss": ".b { c: datauri('e.ico'); }\n",
+		"css/lib/e.ico":   "lib ico",
+		"inc/e.ico":       "inc ico",
+	})
+	defer os.RemoveAll(dir)
+
+	defer func(path string) {
+		PathToSass = path
+	}(PathToSass)
+	PathToSass = filepath.Join(dir, "sass")
+	if err := os.Chmod(PathToSass, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewConfig(None)
+	c.ScssIncludes = []string{filepath.Join(dir, "inc")}
+
+	encode := func(data string) string {
+		return "url(\"data:image/x-icon;base64," + base64.StdEncoding.EncodeToString([]byte(data)) + "\")"
+	}
+
+	var buf bytes.Buffer
+	if err := streamScss(context.Background(), c, filepath.Join(dir, "css/a.scss"), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ".a { b: " + encode("inc ico") + "; }\n.b { c: " + encode("lib ico") + "; }\n"
+	if !strings.HasSuffix(buf.String(), expected) {
+		t.Fatalf("expected %s, got %s", expected, buf.String())
+	}
+
+	src := filepath.Join(dir, "css/broken.scss")
+	err := streamScss(context.Background(), c, src, &bytes.Buffer{})
+	var ce *CompileError
+	if !errors.As(err, &ce) || len(ce.Diagnostics) != 1 || ce.Diagnostics[0].File != src {
+		t.Fatalf("expected an error in %s, got %v", src, err)
+	}
+}
+
+// Both flavors leave datauri() in the output, so a sass that copies the
+// source stands in for either of them.
+func TestScssDataURIFlavors(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "pork-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string) {
+		PathToSass = path
+	}(PathToSass)
+
+	versions := map[string]string{
+		"dart": "1.77.8 compiled with dart2js 3.4.0",
+		"ruby": "Ruby Sass 3.7.4",
+	}
+	for name, version := range versions {
+		PathToSass = filepath.Join(dir, name)
+		script := strings.Replace(fakeSass, "1.77.8 compiled with dart2js 3.4.0", version, 1)
+		if err := ioutil.WriteFile(PathToSass, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		src := filepath.Join(Root(), "tests/scss/datauri.scss")
+		if err := streamScss(context.Background(), NewConfig(None), src, &buf); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if !strings.Contains(buf.String(), "data:image/png;base64") {
+			t.Errorf("%s: datauri did not produce base64: %s", name, buf.String())
+		}
+
+		dst := filepath.Join(dir, name+".css")
+		if err := CompileScss(NewConfig(None), src, dst); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if b, err := ioutil.ReadFile(dst); err != nil {
+			t.Fatal(err)
+		} else if !strings.Contains(string(b), "data:image/png;base64") {
+			t.Errorf("%s: datauri did not produce base64: %s", name, b)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+#!/usr/bin/env ruby
+
+# A long-running Sass compiler for pork. Each line of stdin is a JSON
+# request and each response is written to stdout as a line of JSON.
+#
+#   request:  {"src": "/path/to/a.main.scss", "style": "compressed", "load_paths": []}
+#   response: {"output": "...", "diagnostics": "...", "failed": false}
+#
+# Errors are formatted the way sass --trace prints them so that pork can
+# parse them the same way. As with the sass command, datauri() is left in
+# the output for pork to expand.
+
+require 'json'
+require 'sass'
+
+STDOUT.sync = true
+
+STDIN.each_line do |line|
+  res = { 'output' => '', 'diagnostics' => '', 'failed' => false }
+  begin
+    req = JSON.parse(line)
+    options = {
+      :syntax => :scss,
+      :cache => false,
+      :style => (req['style'] || 'nested').to_sym,
+      :load_paths => req['load_paths'] || [],
+    }
+    res['output'] = Sass::Engine.for_file(req['src'], options).render
+  rescue Sass::SyntaxError => e
+    res['failed'] = true
+    res['diagnostics'] = "#{e.sass_filename}:#{e.sass_line}: #{e.message} (Sass::SyntaxError)"
+  rescue StandardError => e
+    res['failed'] = true
+    res['diagnostics'] = "Error: #{e.message}"
+  end
+  STDOUT.puts(JSON.generate(res))
+end
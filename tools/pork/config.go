@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kellegous/pork"
+)
+
+// The name of the project configuration file that is picked up from the
+// current directory when --config is not given.
+const projectConfigFile = "pork.json"
+
+// The paths to the external tools used by pork.
+type toolsConfig struct {
+	Sass            string `json:"sass"`
+	Tsc             string `json:"tsc"`
+	Jsx             string `json:"jsx"`
+	ClosureCompiler string `json:"closure_compiler"`
+	Java            string `json:"java"`
+	Ruby            string `json:"ruby"`
+	Node            string `json:"node"`
+}
+
+// The closure-compiler settings.
+type jscConfig struct {
+	LanguageIn  string                 `json:"language_in"`
+	LanguageOut string                 `json:"language_out"`
+	Defines     map[string]interface{} `json:"defines"`
+	Warnings    []string               `json:"warnings"`
+	Errors      []string               `json:"errors"`
+}
+
+// The contents of a pork.json project file. Relative paths are resolved
+// against the directory that contains the file.
+type projectConfig struct {
+	Addr             string              `json:"addr"`
+	Out              string              `json:"out"`
+	Opt              string              `json:"opt"`
+	Roots            []string            `json:"roots"`
+	Mounts           map[string][]string `json:"mounts"`
+	Files            map[string]string   `json:"files"`
+	Headers          map[string]string   `json:"headers"`
+	JsxIncludes      []string            `json:"jsx_includes"`
+	JsxExterns       []string            `json:"jsx_externs"`
+	JsxMode          string              `json:"jsx_mode"`
+	ScssIncludes     []string            `json:"scss_includes"`
+	JsIncludes       []string            `json:"js_includes"`
+	TscFlags         []string            `json:"tsc_flags"`
+	JsOptimizer      string              `json:"js_optimizer"`
+	Jsc              jscConfig           `json:"closure"`
+	RemoveStale      bool                `json:"remove_stale"`
+	WarningsAsErrors bool                `json:"warnings_as_errors"`
+	Timeout          string              `json:"timeout"`
+	MaxCompiles      int                 `json:"max_compiles"`
+	Workers          int                 `json:"workers"`
+	Tools            toolsConfig         `json:"tools"`
+}
+
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+func resolvePaths(dir string, paths []string) []string {
+	res := make([]string, len(paths))
+	for i, path := range paths {
+		res[i] = resolvePath(dir, path)
+	}
+	return res
+}
+
+// Only tool paths that look like paths (as opposed to bare command names
+// that are looked up on the PATH) are resolved.
+func resolveToolPath(dir, path string) string {
+	if !strings.ContainsRune(path, filepath.Separator) && !strings.ContainsRune(path, '/') {
+		return path
+	}
+
+	// joining cleans ./tool down to a bare name that would be looked up
+	// on the PATH
+	res := resolvePath(dir, path)
+	if !strings.ContainsRune(res, filepath.Separator) {
+		res = "." + string(filepath.Separator) + res
+	}
+	return res
+}
+
+// Loads the project configuration. When filename is empty, pork.json in
+// the current directory is used if it exists. A missing default file
+// results in an empty configuration.
+func loadProjectConfig(filename string) (*projectConfig, error) {
+	required := filename != ""
+	if !required {
+		filename = projectConfigFile
+	}
+
+	r, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return &projectConfig{}, nil
+		}
+		return nil, err
+	}
+	defer r.Close()
+
+	var p projectConfig
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&p); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	dir := filepath.Dir(filename)
+	p.Out = resolvePath(dir, p.Out)
+	p.Roots = resolvePaths(dir, p.Roots)
+	for prefix, dirs := range p.Mounts {
+		p.Mounts[prefix] = resolvePaths(dir, dirs)
+	}
+	for route, path := range p.Files {
+		p.Files[route] = resolvePath(dir, path)
+	}
+	p.JsxIncludes = resolvePaths(dir, p.JsxIncludes)
+	p.JsxExterns = resolvePaths(dir, p.JsxExterns)
+	p.ScssIncludes = resolvePaths(dir, p.ScssIncludes)
+	p.JsIncludes = resolvePaths(dir, p.JsIncludes)
+	p.Tools.Sass = resolveToolPath(dir, p.Tools.Sass)
+	p.Tools.Tsc = resolveToolPath(dir, p.Tools.Tsc)
+	p.Tools.Jsx = resolveToolPath(dir, p.Tools.Jsx)
+	p.Tools.ClosureCompiler = resolveToolPath(dir, p.Tools.ClosureCompiler)
+	p.Tools.Java = resolveToolPath(dir, p.Tools.Java)
+	p.Tools.Ruby = resolveToolPath(dir, p.Tools.Ruby)
+	p.Tools.Node = resolveToolPath(dir, p.Tools.Node)
+
+	return &p, nil
+}
+
+func parseJsOptimizer(v string) (pork.JsOptimizer, error) {
+	switch strings.ToLower(v) {
+	case "", "auto":
+		return pork.AutoJsOptimizer, nil
+	case "closure":
+		return pork.ClosureJsOptimizer, nil
+	case "builtin":
+		return pork.BuiltinJsOptimizer, nil
+	}
+	return pork.AutoJsOptimizer, fmt.Errorf("invalid js optimizer: %s", v)
+}
+
+// The modes of tsc's --jsx that compile into a single script, along with
+// legacy for the jsx compiler. react-jsx and react-jsxdev need a module
+// loader for react/jsx-runtime and preserve and react-native leave the
+// JSX in place.
+var jsxModes = map[string]bool{
+	"react": true,
+}
+
+func parseJsxMode(v string) (string, error) {
+	switch v = strings.ToLower(v); {
+	case v == "" || v == "legacy":
+		return pork.LegacyJsxMode, nil
+	case jsxModes[v]:
+		return v, nil
+	}
+	return pork.LegacyJsxMode, fmt.Errorf("invalid jsx mode: %s", v)
+}
+
+// A url path mapped to a local path, given on the command line as
+// /url/path=local/path.
+type mapping struct {
+	route string
+	path  string
+}
+
+// A flag that can be repeated to collect many mappings.
+type mappingFlag []mapping
+
+func (m *mappingFlag) String() string {
+	var s []string
+	for _, v := range *m {
+		s = append(s, v.route+"="+v.path)
+	}
+	return strings.Join(s, ",")
+}
+
+func (m *mappingFlag) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i <= 0 || i == len(v)-1 || v[0] != '/' {
+		return fmt.Errorf("expected /path=local/path, got %s", v)
+	}
+	*m = append(*m, mapping{route: v[:i], path: v[i+1:]})
+	return nil
+}
+
+// Mount prefixes always end in a slash so that the router matches every
+// path beneath them.
+func mountPrefix(prefix string) string {
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// Overrides the mounts and files in the configuration with those given
+// on the command line. Mounts on the same prefix are combined.
+func (p *projectConfig) applyMappings(mounts, files mappingFlag) {
+	if len(mounts) > 0 {
+		fromFlags := map[string][]string{}
+		for _, m := range mounts {
+			prefix := mountPrefix(m.route)
+			fromFlags[prefix] = append(fromFlags[prefix], m.path)
+		}
+
+		if p.Mounts == nil {
+			p.Mounts = map[string][]string{}
+		}
+		for prefix, paths := range fromFlags {
+			p.Mounts[prefix] = paths
+		}
+	}
+
+	if len(files) > 0 && p.Files == nil {
+		p.Files = map[string]string{}
+	}
+	for _, f := range files {
+		p.Files[f.route] = f.path
+	}
+}
+
+// Returns the directories to mount at each prefix. Roots are mounted at /
+// ahead of any other directories mounted there.
+func (p *projectConfig) mounts(args []string) map[string][]http.Dir {
+	res := map[string][]http.Dir{}
+	if dirs := p.dirs(args); len(dirs) > 0 {
+		res["/"] = dirs
+	}
+
+	for prefix, paths := range p.Mounts {
+		prefix = mountPrefix(prefix)
+		for _, path := range paths {
+			res[prefix] = append(res[prefix], http.Dir(path))
+		}
+	}
+	return res
+}
+
+// A directory that build productionizes and where its outputs go.
+type buildTarget struct {
+	src http.Dir
+	out http.Dir
+}
+
+// Returns every directory serve would mount, in prefix order, along with
+// where build writes its outputs. With an out directory, each mount is
+// built into the matching path under it; otherwise each is built in place.
+func (p *projectConfig) targets(args []string) []buildTarget {
+	mounts := p.mounts(args)
+
+	prefixes := make([]string, 0, len(mounts))
+	for prefix := range mounts {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	var res []buildTarget
+	for _, prefix := range prefixes {
+		for _, dir := range mounts[prefix] {
+			out := dir
+			if p.Out != "" {
+				out = http.Dir(filepath.Join(p.Out, filepath.FromSlash(prefix)))
+			}
+			res = append(res, buildTarget{src: dir, out: out})
+		}
+	}
+	return res
+}
+
+// Returns the distinct directories that build writes its outputs to.
+func (p *projectConfig) outs(args []string) []http.Dir {
+	var res []http.Dir
+	seen := map[http.Dir]bool{}
+	for _, t := range p.targets(args) {
+		if !seen[t.out] {
+			seen[t.out] = true
+			res = append(res, t.out)
+		}
+	}
+	return res
+}
+
+// Returns the names of the flags that were given on the command line.
+func flagsSet(flags *flag.FlagSet) map[string]bool {
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// Points pork at any tools named in the configuration.
+func (p *projectConfig) applyTools() {
+	if p.Tools.Sass != "" {
+		pork.PathToSass = p.Tools.Sass
+	}
+	if p.Tools.Tsc != "" {
+		pork.PathToTsc = p.Tools.Tsc
+	}
+	if p.Tools.Jsx != "" {
+		pork.PathToJsx = p.Tools.Jsx
+	}
+	if p.Tools.ClosureCompiler != "" {
+		pork.PathToClosureCompiler = p.Tools.ClosureCompiler
+	}
+	if p.Tools.Java != "" {
+		pork.PathToJava = p.Tools.Java
+	}
+	if p.Tools.Ruby != "" {
+		pork.PathToRuby = p.Tools.Ruby
+	}
+	if p.Tools.Node != "" {
+		pork.PathToNode = p.Tools.Node
+	}
+}
+
+// Creates the pork.Config described by the project configuration.
+func (p *projectConfig) config() (*pork.Config, error) {
+	lvl := pork.None
+	if p.Opt != "" {
+		l, err := parseOptimization(p.Opt)
+		if err != nil {
+			return nil, err
+		}
+		lvl = l
+	}
+
+	jso, err := parseJsOptimizer(p.JsOptimizer)
+	if err != nil {
+		return nil, err
+	}
+
+	jsxMode, err := parseJsxMode(p.JsxMode)
+	if err != nil {
+		return nil, err
+	}
+
+	var timeout time.Duration
+	if p.Timeout != "" {
+		timeout, err = time.ParseDuration(p.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %s", p.Timeout)
+		}
+	}
+
+	c := pork.NewConfig(lvl)
+	c.JsxIncludes = p.JsxIncludes
+	c.JsxExterns = p.JsxExterns
+	c.JsxMode = jsxMode
+	c.ScssIncludes = p.ScssIncludes
+	c.JsIncludes = p.JsIncludes
+	c.TscFlags = p.TscFlags
+	c.JsOptimizer = jso
+	c.JscLanguageIn = p.Jsc.LanguageIn
+	c.JscLanguageOut = p.Jsc.LanguageOut
+	c.JscDefines = p.Jsc.Defines
+	c.JscWarnings = p.Jsc.Warnings
+	c.JscErrors = p.Jsc.Errors
+	c.RemoveStale = p.RemoveStale
+	c.WarningsAsErrors = p.WarningsAsErrors
+	c.Timeout = timeout
+	c.MaxConcurrentCompiles = p.MaxCompiles
+	c.Workers = p.Workers
+	return c, nil
+}
+
+// The roots named on the command line, falling back to the roots in the
+// configuration and then to the current directory.
+func (p *projectConfig) dirs(args []string) []http.Dir {
+	if len(args) == 0 {
+		args = p.Roots
+	}
+
+	if len(args) == 0 && len(p.Mounts) == 0 {
+		args = []string{"."}
+	}
+
+	dirs := make([]http.Dir, len(args))
+	for i, arg := range args {
+		dirs[i] = http.Dir(arg)
+	}
+	return dirs
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Build and clean resolve the same directories as serve, including a
+// configuration that only has mounts.
+func TestTargets(t *testing.T) {
+	out := filepath.FromSlash("/tmp/out")
+
+	tests := []struct {
+		proj     projectConfig
+		args     []string
+		expected []buildTarget
+	}{
+		{
+			proj:     projectConfig{},
+			expected: []buildTarget{{src: "."}},
+		},
+		{
+			proj:     projectConfig{Roots: []string{"a", "b"}, Out: out},
+			expected: []buildTarget{{src: "a"}, {src: "b"}},
+		},
+		{
+			proj: projectConfig{Roots: []string{"a"}},
+			args: []string{"c"},
+			expected: []buildTarget{
+				{src: "c", out: "c"},
+			},
+		},
+		{
+			proj: projectConfig{Mounts: map[string][]string{"/static": {"s"}, "/lib/": {"l"}}},
+			expected: []buildTarget{
+				{src: "l", out: "l"},
+				{src: "s", out: "s"},
+			},
+		},
+		{
+			proj: projectConfig{
+				Roots:  []string{"a"},
+				Mounts: map[string][]string{"/static/": {"s"}},
+				Out:    out,
+			},
+			expected: []buildTarget{
+				{src: "a"},
+				{src: "s", out: http.Dir(filepath.Join(out, "static"))},
+			},
+		},
+	}
+
+	for i, test := range tests {
+		for j := range test.expected {
+			if test.expected[j].out == "" {
+				test.expected[j].out = test.expected[j].src
+				if test.proj.Out != "" {
+					test.expected[j].out = http.Dir(out)
+				}
+			}
+		}
+
+		targets := test.proj.targets(test.args)
+		if !reflect.DeepEqual(targets, test.expected) {
+			t.Errorf("%d: expected %v, got %v", i, test.expected, targets)
+		}
+	}
+
+	p := projectConfig{Roots: []string{"a", "b"}, Mounts: map[string][]string{"/s/": {"s"}}, Out: out}
+	expected := []http.Dir{http.Dir(out), http.Dir(filepath.Join(out, "s"))}
+	if outs := p.outs(nil); !reflect.DeepEqual(outs, expected) {
+		t.Errorf("expected %v, got %v", expected, outs)
+	}
+}
+
+func writeConfig(t *testing.T, config string) string {
+	dir, err := ioutil.TempDir("", "pork-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(dir, projectConfigFile)
+	if err := ioutil.WriteFile(filename, []byte(config), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadProjectConfigUnknownField(t *testing.T) {
+	filename := writeConfig(t, `{"roots": ["src"], "rots": ["typo"]}`)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	_, err := loadProjectConfig(filename)
+	if err == nil || !strings.Contains(err.Error(), "rots") {
+		t.Fatalf("expected an error naming the unknown field, got %v", err)
+	}
+}
+
+func TestLoadProjectConfigMissing(t *testing.T) {
+	if _, err := loadProjectConfig(filepath.Join(os.TempDir(), "pork-none", "pork.json")); err == nil {
+		t.Fatal("expected an error for a missing --config")
+	}
+}
+
+// Relative paths are resolved against the directory that holds the
+// configuration, not the working directory.
+func TestLoadProjectConfigPaths(t *testing.T) {
+	filename := writeConfig(t, `{
+		"out": "build",
+		"roots": ["src", "/abs/src"],
+		"mounts": {"/static/": ["assets"]},
+		"files": {"/robots.txt": "robots.txt"},
+		"scss_includes": ["scss"],
+		"js_includes": ["js"],
+		"jsx_includes": ["jsx"],
+		"jsx_externs": ["externs.js"],
+		"tools": {"sass": "sass", "tsc": "./node_modules/.bin/tsc", "java": "/usr/bin/java"}
+	}`)
+	dir := filepath.Dir(filename)
+	defer os.RemoveAll(dir)
+
+	p, err := loadProjectConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, v := range map[string][2]interface{}{
+		"out":           {p.Out, filepath.Join(dir, "build")},
+		"roots":         {p.Roots, []string{filepath.Join(dir, "src"), "/abs/src"}},
+		"mounts":        {p.Mounts, map[string][]string{"/static/": {filepath.Join(dir, "assets")}}},
+		"files":         {p.Files, map[string]string{"/robots.txt": filepath.Join(dir, "robots.txt")}},
+		"scss_includes": {p.ScssIncludes, []string{filepath.Join(dir, "scss")}},
+		"js_includes":   {p.JsIncludes, []string{filepath.Join(dir, "js")}},
+		"jsx_includes":  {p.JsxIncludes, []string{filepath.Join(dir, "jsx")}},
+		"jsx_externs":   {p.JsxExterns, []string{filepath.Join(dir, "externs.js")}},
+		"tools.sass":    {p.Tools.Sass, "sass"},
+		"tools.tsc":     {p.Tools.Tsc, filepath.Join(dir, "node_modules/.bin/tsc")},
+		"tools.java":    {p.Tools.Java, "/usr/bin/java"},
+		"tools.node":    {p.Tools.Node, ""},
+	} {
+		if !reflect.DeepEqual(v[0], v[1]) {
+			t.Errorf("%s: expected %v, got %v", name, v[1], v[0])
+		}
+	}
+}
+
+func TestResolveToolPath(t *testing.T) {
+	sep := string(filepath.Separator)
+	tests := []struct {
+		dir, path, expected string
+	}{
+		{"conf", "", ""},
+		{"conf", "sass", "sass"},
+		{"conf", "/usr/bin/sass", "/usr/bin/sass"},
+		{"conf", "bin/sass", filepath.Join("conf", "bin", "sass")},
+		{"conf", "./sass", filepath.Join("conf", "sass")},
+		// a configuration in the working directory must not turn ./sass
+		// into a bare name that is looked up on the PATH
+		{".", "./sass", "." + sep + "sass"},
+		{".", "bin/sass", filepath.Join("bin", "sass")},
+	}
+
+	for _, test := range tests {
+		if res := resolveToolPath(test.dir, test.path); res != test.expected {
+			t.Errorf("resolveToolPath(%q, %q): expected %q, got %q", test.dir, test.path, test.expected, res)
+		}
+	}
+}
+
+// Only the flags given on the command line override the configuration,
+// even when they are given their default value.
+func TestFlagsSet(t *testing.T) {
+	flags := flag.NewFlagSet("", flag.ContinueOnError)
+	flagOpt := flags.String("opt", "None", "")
+	flags.String("timeout", "", "")
+	flagOut := flags.String("out", "", "")
+	if err := flags.Parse([]string{"--opt=None", "--out", "dist", "dir"}); err != nil {
+		t.Fatal(err)
+	}
+
+	set := flagsSet(flags)
+	if !reflect.DeepEqual(set, map[string]bool{"opt": true, "out": true}) {
+		t.Fatalf("unexpected flags: %v", set)
+	}
+
+	p := projectConfig{Opt: "Advanced", Out: "build", Timeout: "30s"}
+	if set["opt"] {
+		p.Opt = *flagOpt
+	}
+	if set["out"] {
+		p.Out = *flagOut
+	}
+	if set["timeout"] {
+		t.Fatal("expected timeout to keep the configured value")
+	}
+	if p.Opt != "None" || p.Out != "dist" || p.Timeout != "30s" {
+		t.Fatalf("unexpected configuration: %+v", p)
+	}
+}
+
+func TestMappingFlag(t *testing.T) {
+	var m mappingFlag
+	for _, v := range []string{"/static=assets", "/a/b/=c=d"} {
+		if err := m.Set(v); err != nil {
+			t.Fatalf("%s: %s", v, err)
+		}
+	}
+
+	expected := mappingFlag{{route: "/static", path: "assets"}, {route: "/a/b/", path: "c=d"}}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("expected %v, got %v", expected, m)
+	}
+	if s := m.String(); s != "/static=assets,/a/b/=c=d" {
+		t.Fatalf("unexpected string: %s", s)
+	}
+
+	for _, v := range []string{"", "static=assets", "=assets", "/static=", "/static"} {
+		if err := m.Set(v); err == nil {
+			t.Errorf("%q: expected an error", v)
+		}
+	}
+}
+
+// Mounts on the command line replace those on the same prefix in the
+// configuration and keep the others.
+func TestApplyMappings(t *testing.T) {
+	p := projectConfig{
+		Mounts: map[string][]string{"/static/": {"assets"}, "/lib/": {"lib"}},
+		Files:  map[string]string{"/a.txt": "a.txt"},
+	}
+
+	p.applyMappings(
+		mappingFlag{{route: "/static", path: "x"}, {route: "/static/", path: "y"}},
+		mappingFlag{{route: "/b.txt", path: "b.txt"}})
+
+	mounts := map[string][]string{"/static/": {"x", "y"}, "/lib/": {"lib"}}
+	if !reflect.DeepEqual(p.Mounts, mounts) {
+		t.Errorf("expected %v, got %v", mounts, p.Mounts)
+	}
+
+	files := map[string]string{"/a.txt": "a.txt", "/b.txt": "b.txt"}
+	if !reflect.DeepEqual(p.Files, files) {
+		t.Errorf("expected %v, got %v", files, p.Files)
+	}
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/kellegous/pork"
 )
@@ -37,31 +43,101 @@ func helpServe(w io.Writer) {
 		"  options:",
 		"  --addr=addr    the address to which the http server will bind (default: \":8082\")",
 		"  --opt=level    the pork optimization level (None, Basic, Advanced)",
+		"  --timeout=d    stop any compile that takes longer than d (e.g. 30s)",
+		"  --config=path  the project configuration file (default: pork.json, if present)",
+		"  --mount=/prefix=dir",
+		"                 serve dir beneath /prefix (may be repeated)",
+		"  --file=/path=file",
+		"                 serve a single file at /path (may be repeated)",
+		"  --max-compiles=n",
+		"                 the most files to compile at the same time (default: no limit)",
+		"  --workers=n    keep n long-running sass, tsc and closure-compiler processes",
+		"                 rather than starting one for every request (sass workers need",
+		"                 ruby and the sass gem and are not used with Dart Sass, tsc",
+		"                 workers need node)",
 		"",
 	})
 }
 
+func exitWithError(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
 func mainServe(args []string) {
 	flags := flag.NewFlagSet("", flag.ExitOnError)
 	flagAddr := flags.String("addr", ":8082", "address to bind")
+	flagOpt := flags.String("opt", "None", "")
+	flagTimeout := flags.String("timeout", "", "")
+	flagMaxCompiles := flags.Int("max-compiles", 0, "")
+	flagWorkers := flags.Int("workers", 0, "")
+	flagConfig := flags.String("config", "", "")
+	var flagMounts, flagFiles mappingFlag
+	flags.Var(&flagMounts, "mount", "")
+	flags.Var(&flagFiles, "file", "")
 	flags.Parse(args)
 
-	var dirs []http.Dir
-	if flags.NArg() == 0 {
-		dirs = append(dirs, http.Dir("."))
-	} else {
-		for _, arg := range flags.Args() {
-			dirs = append(dirs, http.Dir(arg))
-		}
+	proj, err := loadProjectConfig(*flagConfig)
+	if err != nil {
+		exitWithError(err)
+	}
+	proj.applyMappings(flagMounts, flagFiles)
+
+	set := flagsSet(flags)
+	if set["addr"] || proj.Addr == "" {
+		proj.Addr = *flagAddr
+	}
+	if set["opt"] {
+		proj.Opt = *flagOpt
+	}
+	if set["timeout"] {
+		proj.Timeout = *flagTimeout
 	}
+	if set["max-compiles"] {
+		proj.MaxCompiles = *flagMaxCompiles
+	}
+	if set["workers"] {
+		proj.Workers = *flagWorkers
+	}
+
+	cfg, err := proj.config()
+	if err != nil {
+		exitWithError(err)
+	}
+	proj.applyTools()
 
 	r := pork.NewRouter(func(status int, r *http.Request) {
 		log.Printf("[%d] %s", status, r.RequestURI)
-	}, nil, nil)
+	}, nil, proj.Headers)
+
+	var handlers []pork.Handler
+	for prefix, dirs := range proj.mounts(flags.Args()) {
+		h := pork.Content(cfg, dirs...)
+		r.RespondWith(prefix, h)
+		handlers = append(handlers, h)
+	}
+
+	for route, path := range proj.Files {
+		if _, err := os.Stat(path); err != nil {
+			exitWithError(err)
+		}
+		r.RespondWith(route, pork.FileResponder(path))
+	}
 
-	r.RespondWith("/", pork.Content(pork.NewConfig(pork.None), dirs...))
+	// stop serving on an interrupt so that the workers are stopped too
+	srv := &http.Server{Addr: proj.Addr, Handler: r}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		srv.Shutdown(context.Background())
+	}()
 
-	if err := http.ListenAndServe(*flagAddr, r); err != nil {
+	err = srv.ListenAndServe()
+	for _, h := range handlers {
+		h.Close()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		log.Panic(err)
 	}
 }
@@ -73,42 +149,477 @@ func helpBuild(w io.Writer) {
 		"  options:",
 		"  --out=path     the path to write the output. the default is to write into the pork directory.",
 		"  --opt=level    the pork optimization level (None, Basic, Advanced)",
+		"  --timeout=d    stop any compile that takes longer than d (e.g. 30s)",
+		"  --config=path  the project configuration file (default: pork.json, if present)",
+		"  --watch        keep running and rebuild the outputs affected by each change",
+		"  --interval=d   how often to check for changes when watching (default: 500ms)",
+		"  --remove-stale delete outputs from earlier builds that the build no longer produces",
+		"  --warnings-as-errors",
+		"                 fail the build when closure-compiler reports any warnings",
+		"  --workers=n    keep n long-running compiler processes (see serve), which saves",
+		"                 starting a JVM for every file closure-compiler optimizes",
+		"",
+		"  builds every directory that serve would mount. with --out, the outputs of",
+		"  a directory mounted at /prefix/ go under out/prefix.",
 		"",
 	})
 }
 
+// Prints the warnings reported while building and keeps count of them.
+type warningCounter struct {
+	files    int
+	warnings int
+}
+
+func (w *warningCounter) log(src string, warnings []pork.Diagnostic) {
+	w.files++
+	w.warnings += len(warnings)
+
+	log.Printf("%s: %s", src, plural(len(warnings), "warning"))
+	for _, d := range warnings {
+		log.Printf("  %s", d)
+	}
+}
+
+func (w *warningCounter) summarize() {
+	if w.warnings > 0 {
+		log.Printf("%s in %s", plural(w.warnings, "warning"), plural(w.files, "file"))
+	}
+	w.files, w.warnings = 0, 0
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// Calls each of the refresh functions returned by Productionize whenever
+// the sources change. Errors are printed and watching continues until the
+// process is interrupted. The warnings of each round of refreshes are
+// summarized once it is done.
+func watch(cfg *pork.Config, refreshes []func() error, warnings *warningCounter,
+	interval time.Duration) {
+	cfg.BuildLogger = func(src, dst string) {
+		log.Printf("built %s", dst)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	log.Printf("watching for changes")
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-sig:
+			return
+		}
+
+		for _, refresh := range refreshes {
+			if err := refresh(); err != nil {
+				log.Print(err)
+			}
+		}
+		warnings.summarize()
+	}
+}
+
 func mainBuild(args []string) {
 	flags := flag.NewFlagSet("", flag.ExitOnError)
 	flagOut := flags.String("out", "", "")
 	flagOpt := flags.String("opt", "None", "")
+	flagTimeout := flags.String("timeout", "", "")
+	flagConfig := flags.String("config", "", "")
+	flagWatch := flags.Bool("watch", false, "")
+	flagInterval := flags.Duration("interval", 500*time.Millisecond, "")
+	flagRemoveStale := flags.Bool("remove-stale", false, "")
+	flagWarningsAsErrors := flags.Bool("warnings-as-errors", false, "")
+	flagWorkers := flags.Int("workers", 0, "")
+	flags.Parse(args)
+
+	proj, err := loadProjectConfig(*flagConfig)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	set := flagsSet(flags)
+	if set["out"] {
+		proj.Out = *flagOut
+	}
+	if set["opt"] {
+		proj.Opt = *flagOpt
+	}
+	if set["timeout"] {
+		proj.Timeout = *flagTimeout
+	}
+	if set["remove-stale"] {
+		proj.RemoveStale = *flagRemoveStale
+	}
+	if set["warnings-as-errors"] {
+		proj.WarningsAsErrors = *flagWarningsAsErrors
+	}
+	if set["workers"] {
+		proj.Workers = *flagWorkers
+	}
+
+	cfg, err := proj.config()
+	if err != nil {
+		exitWithError(err)
+	}
+	proj.applyTools()
+
+	var warnings warningCounter
+	cfg.WarningLogger = warnings.log
+
+	var refreshes []func() error
+	for _, t := range proj.targets(flags.Args()) {
+		refresh, err := pork.Content(cfg, t.src).Productionize(t.out)
+		if err != nil {
+			if !*flagWatch || refresh == nil {
+				cfg.Close()
+				log.Panic(err)
+			}
+			log.Print(err)
+		}
+		refreshes = append(refreshes, refresh)
+	}
+
+	warnings.summarize()
+
+	if *flagWatch {
+		watch(cfg, refreshes, &warnings, *flagInterval)
+	}
+	cfg.Close()
+}
+
+func helpCompile(w io.Writer) {
+	print(w, []string{
+		"  pork compile [options] file",
+		"",
+		"  options:",
+		"  --out=path     the path to write the output (default: stdout)",
+		"  --opt=level    the pork optimization level (None, Basic, Advanced)",
+		"  --timeout=d    stop any compile that takes longer than d (e.g. 30s)",
+		"  --config=path  the project configuration file (default: pork.json, if present)",
+		"",
+		"  compiles a single .main.scss, .main.ts, .main.tsx, .main.jsx, .main.js or",
+		"  .main.list file exactly as serve would.",
+		"",
+	})
+}
+
+func mainCompile(args []string) {
+	flags := flag.NewFlagSet("", flag.ExitOnError)
+	flagOut := flags.String("out", "", "")
+	flagOpt := flags.String("opt", "None", "")
+	flagTimeout := flags.String("timeout", "", "")
+	flagConfig := flags.String("config", "", "")
 	flags.Parse(args)
 
-	lvl, err := parseOptimization(*flagOpt)
+	if flags.NArg() != 1 {
+		printHelp(os.Stderr, "compile")
+	}
+
+	proj, err := loadProjectConfig(*flagConfig)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "invalid optimization level: %s\n", *flagOpt)
+		exitWithError(err)
+	}
+
+	set := flagsSet(flags)
+	if set["opt"] {
+		proj.Opt = *flagOpt
+	}
+	if set["timeout"] {
+		proj.Timeout = *flagTimeout
+	}
+
+	cfg, err := proj.config()
+	if err != nil {
+		exitWithError(err)
+	}
+	proj.applyTools()
+
+	src := flags.Arg(0)
+	if _, err := os.Stat(src); err != nil {
+		exitWithError(err)
+	}
+
+	if *flagOut == "" {
+		if err := pork.CompileFile(cfg, src, os.Stdout); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	w, err := os.Create(*flagOut)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if err := pork.CompileFile(cfg, src, w); err != nil {
+		w.Close()
+		os.Remove(*flagOut)
+		exitWithError(err)
+	}
+
+	if err := w.Close(); err != nil {
+		exitWithError(err)
+	}
+}
+
+func helpDeps(w io.Writer) {
+	print(w, []string{
+		"  pork deps [options] dir...",
+		"",
+		"  options:",
+		"  --format=fmt   the output format (text, json, dot) (default: text)",
+		"  --reverse=file list the sources that need rebuilding when file changes",
+		"  --config=path  the project configuration file (default: pork.json, if present)",
+		"",
+		"  prints the files that each source is built from, following //@include",
+		"  and #include directives, es module imports, sass imports and datauri()",
+		"  references.",
+		"",
+	})
+}
+
+func mainDeps(args []string) {
+	flags := flag.NewFlagSet("", flag.ExitOnError)
+	flagFormat := flags.String("format", "text", "")
+	flagReverse := flags.String("reverse", "", "")
+	flagConfig := flags.String("config", "", "")
+	flags.Parse(args)
+
+	proj, err := loadProjectConfig(*flagConfig)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	cfg, err := proj.config()
+	if err != nil {
+		exitWithError(err)
 	}
 
 	var dirs []http.Dir
-	if flags.NArg() == 0 {
-		dirs = append(dirs, http.Dir("."))
+	for _, ds := range proj.mounts(flags.Args()) {
+		dirs = append(dirs, ds...)
+	}
+
+	g, err := pork.Deps(cfg, dirs...)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if *flagReverse != "" {
+		if _, err := os.Stat(*flagReverse); err != nil {
+			exitWithError(err)
+		}
+		err = writeReverse(os.Stdout, *flagFormat, *flagReverse, g.Reverse(*flagReverse))
 	} else {
-		for _, arg := range flags.Args() {
-			dirs = append(dirs, http.Dir(arg))
+		switch strings.ToLower(*flagFormat) {
+		case "text":
+			err = g.WriteText(os.Stdout)
+		case "json":
+			err = g.WriteJSON(os.Stdout)
+		case "dot":
+			err = g.WriteDot(os.Stdout)
+		default:
+			err = fmt.Errorf("invalid format: %s", *flagFormat)
 		}
 	}
 
-	for _, dir := range dirs {
-		out := dir
-		if *flagOut != "" {
-			out = http.Dir(*flagOut)
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
+// Writes the entries that depend on file.
+func writeReverse(w io.Writer, format, file string, entries []string) error {
+	rels := []string{}
+	for _, entry := range entries {
+		rels = append(rels, pork.DisplayPath(entry))
+	}
+
+	switch strings.ToLower(format) {
+	case "text":
+		print(w, rels)
+	case "json":
+		data, err := json.MarshalIndent(rels, "", "  ")
+		if err != nil {
+			return err
 		}
+		fmt.Fprintln(w, string(data))
+	case "dot":
+		fmt.Fprintln(w, "digraph deps {")
+		for _, rel := range rels {
+			fmt.Fprintf(w, "  %q -> %q;\n", rel, filepath.Clean(file))
+		}
+		fmt.Fprintln(w, "}")
+	default:
+		return fmt.Errorf("invalid format: %s", format)
+	}
+	return nil
+}
 
-		if _, err := pork.Content(pork.NewConfig(lvl), dir).Productionize(out); err != nil {
-			log.Panic(err)
+func helpClean(w io.Writer) {
+	print(w, []string{
+		"  pork clean [options] dir...",
+		"",
+		"  options:",
+		"  --out=path     the path that was given to build with --out",
+		"  --config=path  the project configuration file (default: pork.json, if present)",
+		"",
+		"  removes the files that pork build generated, leaving any other files",
+		"  in place. it looks in the same directories that build writes to.",
+		"",
+	})
+}
+
+func mainClean(args []string) {
+	flags := flag.NewFlagSet("", flag.ExitOnError)
+	flagOut := flags.String("out", "", "")
+	flagConfig := flags.String("config", "", "")
+	flags.Parse(args)
+
+	proj, err := loadProjectConfig(*flagConfig)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if flagsSet(flags)["out"] {
+		proj.Out = *flagOut
+	}
+
+	for _, dest := range proj.outs(flags.Args()) {
+		if err := pork.Clean(dest); err != nil {
+			exitWithError(err)
 		}
 	}
 }
 
+func helpDoctor(w io.Writer) {
+	print(w, []string{
+		"  pork doctor [options]",
+		"",
+		"  options:",
+		"  --config=path  the project configuration file (default: pork.json, if present)",
+		"",
+		"  checks that the external tools used by pork (sass, tsc, jsx and",
+		"  closure-compiler) can be found and reports which source types are",
+		"  disabled when they cannot.",
+		"",
+	})
+}
+
+func mainDoctor(args []string) {
+	flags := flag.NewFlagSet("", flag.ExitOnError)
+	flagConfig := flags.String("config", "", "")
+	flags.Parse(args)
+
+	proj, err := loadProjectConfig(*flagConfig)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	cfg, err := proj.config()
+	if err != nil {
+		exitWithError(err)
+	}
+	proj.applyTools()
+
+	t := pork.CheckToolchain(cfg)
+	if _, err := t.WriteTo(os.Stdout); err != nil {
+		log.Panic(err)
+	}
+
+	if len(t.Disabled()) > 0 {
+		os.Exit(1)
+	}
+}
+
+func helpConfig(w io.Writer) {
+	print(w, []string{
+		"  pork.json",
+		"",
+		"  serve, build, clean, compile, deps and doctor read a pork.json from the",
+		"  current directory (or the file named by --config). flags given on the",
+		"  command line override the values in the file and relative paths are",
+		"  relative to the file.",
+		"",
+		"  typescript is compiled with the compiler options in the tsconfig.json",
+		"  nearest to each .main.ts, followed by tsc_flags. pork only overrides",
+		"  the output settings.",
+		"",
+		"  closure-compiler is run from the bundled compiler.jar with java when",
+		"  there is no closure-compiler on the PATH.",
+		"",
+		"  a .main.modules file splits javascript into chunks. each chunk is a",
+		"  header, [name] or [name: deps...], followed by the files in it, and is",
+		"  built into <base>.<name>.js. at Basic and Advanced, closure-compiler",
+		"  optimizes the chunks together.",
+		"",
+		"  a request for x.js is answered by the first of x.main.jsx, x.main.tsx,",
+		"  x.main.ts and x.main.js that exists. .main.tsx is compiled by tsc with",
+		"  --jsx set to jsx_mode (when it is legacy, the jsx of tsconfig.json or",
+		"  react). with the default",
+		"  jsx_mode, legacy, .main.jsx is compiled by the jsx compiler. react",
+		"  compiles it with tsc instead. other tsc modes (react-jsx, preserve,",
+		"  ...) cannot be compiled into a single script and are not supported.",
+		"",
+		"  a .main.list concatenates the files it lists, one path or glob per",
+		"  line, into x.js or x.css. a \"# type: js\" or \"# type: css\" line",
+		"  before the files sets the type, otherwise it comes from their",
+		"  extensions. x.js is answered by a .main.list after the other sources.",
+		"",
+		"  a .main.js that imports or exports is bundled with the modules it",
+		"  imports. relative specifiers are resolved against the importing file",
+		"  and all others against js_includes.",
+		"",
+		"  {",
+		"    \"addr\": \":8082\",",
+		"    \"out\": \"build\",",
+		"    \"opt\": \"Basic\",",
+		"    \"roots\": [\"web\"],",
+		"    \"mounts\": {\"/static/\": [\"assets\"]},",
+		"    \"files\": {\"/favicon.ico\": \"icons/fav.ico\"},",
+		"    \"headers\": {\"Cache-Control\": \"no-cache\"},",
+		"    \"jsx_includes\": [],",
+		"    \"jsx_externs\": [],",
+		"    \"jsx_mode\": \"legacy\",",
+		"    \"scss_includes\": [\"scss\"],",
+		"    \"js_includes\": [],",
+		"    \"tsc_flags\": [\"--noImplicitAny\"],",
+		"    \"js_optimizer\": \"auto\",",
+		"    \"closure\": {",
+		"      \"language_in\": \"ECMASCRIPT_2015\",",
+		"      \"language_out\": \"ECMASCRIPT5\",",
+		"      \"defines\": {\"DEBUG\": false, \"VERSION\": \"1.2\"},",
+		"      \"warnings\": [\"checkTypes\"],",
+		"      \"errors\": [\"checkVars\"]",
+		"    },",
+		"    \"remove_stale\": false,",
+		"    \"warnings_as_errors\": false,",
+		"    \"timeout\": \"30s\",",
+		"    \"max_compiles\": 4,",
+		"    \"workers\": 2,",
+		"    \"tools\": {",
+		"      \"sass\": \"sass\",",
+		"      \"tsc\": \"node_modules/.bin/tsc\",",
+		"      \"jsx\": \"jsx\",",
+		"      \"closure_compiler\": \"closure-compiler\",",
+		"      \"java\": \"java\",",
+		"      \"ruby\": \"ruby\",",
+		"      \"node\": \"node\"",
+		"    }",
+		"  }",
+		"",
+	})
+}
+
 func helpMain(w io.Writer) {
 	print(w, []string{
 		"  pork command [options] args...",
@@ -116,7 +627,11 @@ func helpMain(w io.Writer) {
 		"  commands:",
 		"  serve        run a porkifying http server on one or more pork directories",
 		"  build        productionize one or more pork directories",
-		"  help         get help on one of these here commands",
+		"  clean        remove the files that build generated",
+		"  compile      compile a single file to stdout",
+		"  deps         print the graph of files that each source is built from",
+		"  doctor       check the external tools that pork depends on",
+		"  help         get help on one of these here commands (or config)",
 		"",
 	})
 }
@@ -127,6 +642,16 @@ func printHelp(w io.Writer, topic string) {
 		helpServe(w)
 	case "build":
 		helpBuild(w)
+	case "clean":
+		helpClean(w)
+	case "compile":
+		helpCompile(w)
+	case "deps":
+		helpDeps(w)
+	case "doctor":
+		helpDoctor(w)
+	case "config":
+		helpConfig(w)
 	default:
 		helpMain(w)
 	}
@@ -143,6 +668,14 @@ func main() {
 		mainServe(os.Args[2:])
 	case "build":
 		mainBuild(os.Args[2:])
+	case "clean":
+		mainClean(os.Args[2:])
+	case "compile":
+		mainCompile(os.Args[2:])
+	case "deps":
+		mainDeps(os.Args[2:])
+	case "doctor":
+		mainDoctor(os.Args[2:])
 	case "help":
 		var t string
 		if len(os.Args) >= 3 {
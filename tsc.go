@@ -1,17 +1,206 @@
 package pork
 
 import (
+  "context"
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
   "os"
   "os/exec"
+  "path/filepath"
 )
 
-func tscCommand(src, dst string) *exec.Cmd {
-  c := exec.Command(PathToTsc, "--out", dst, src)
-  c.Stderr = os.Stderr
-  c.Stdout = os.Stdout
-  return c
+const tsconfigFileName = "tsconfig.json"
+
+// Finds the tsconfig.json nearest to src by looking in the directory of
+// src and then in each of its parents. Returns an empty string if there
+// is none.
+func findTsconfig(src string) string {
+  dir, err := filepath.Abs(filepath.Dir(src))
+  if err != nil {
+    return ""
+  }
+
+  for {
+    path := filepath.Join(dir, tsconfigFileName)
+    if s, err := os.Stat(path); err == nil && !s.IsDir() {
+      return path
+    }
+
+    parent := filepath.Dir(dir)
+    if parent == dir {
+      return ""
+    }
+    dir = parent
+  }
+}
+
+// The compiler options that pork controls. Everything else comes from
+// the project's tsconfig.json. A null removes the option.
+var tscOutputOptions = map[string]interface{}{
+  "outDir":              nil,
+  "noEmit":              false,
+  "emitDeclarationOnly": false,
+  "declaration":         false,
+  "declarationDir":      nil,
+  "declarationMap":      false,
+  "sourceMap":           false,
+  "inlineSourceMap":     false,
+  "composite":           false,
+  "incremental":         false,
+  "tsBuildInfoFile":     nil,
+}
+
+// Writes a temporary tsconfig.json that extends the project's tsconfig
+// with only src as input and dst as the output.
+func writeTsconfig(tsconfig, src, dst string) (string, error) {
+  src, err := filepath.Abs(src)
+  if err != nil {
+    return "", err
+  }
+
+  dst, err = filepath.Abs(dst)
+  if err != nil {
+    return "", err
+  }
+
+  opts := map[string]interface{}{"outFile": dst}
+  for k, v := range tscOutputOptions {
+    opts[k] = v
+  }
+
+  data, err := json.Marshal(map[string]interface{}{
+    "extends":         tsconfig,
+    "compilerOptions": opts,
+    "files":           []string{src},
+    "include":         []string{},
+  })
+  if err != nil {
+    return "", err
+  }
+
+  f, err := ioutil.TempFile(os.TempDir(), "tsconfig-*.json")
+  if err != nil {
+    return "", err
+  }
+  defer f.Close()
+
+  if _, err := f.Write(data); err != nil {
+    os.Remove(f.Name())
+    return "", err
+  }
+  return f.Name(), nil
+}
+
+// The values of Config.JsxMode that tsc can compile with. Each source is
+// compiled into a single script, which leaves only react: react-jsx and
+// react-jsxdev make every file a module that imports react/jsx-runtime,
+// which tsc cannot put in one script, and preserve and react-native leave
+// the JSX in the output.
+var tscJsxModes = map[string]bool{
+  "react": true,
+}
+
+// Fails when src would be compiled with a Config.JsxMode that cannot
+// produce a script.
+func checkJsxMode(c *Config, src string) error {
+  switch typeOfSrc(src) {
+  case srcOfTsx, srcOfJsx:
+    if mode := tscJsxMode(c); !tscJsxModes[mode] {
+      return fmt.Errorf("%s: jsx mode %s cannot be compiled into a script, use react", src, mode)
+    }
+  }
+  return nil
+}
+
+// The value of tsc's --jsx for .main.tsx, and for .main.jsx when it is
+// not compiled with the legacy jsx compiler.
+func tscJsxMode(c *Config) string {
+  if c.JsxMode == "" {
+    return "react"
+  }
+  return c.JsxMode
+}
+
+// The command line flags for compiling src: what its type needs followed
+// by Config.TscFlags. A .main.tsx in a project with a tsconfig.json only
+// gets --jsx when Config.JsxMode is set, so that the tsconfig's jsx option
+// is not overridden by the default.
+func tscFlags(c *Config, src string) []string {
+  var flags []string
+  switch typeOfSrc(src) {
+  case srcOfTsx:
+    if c.JsxMode != LegacyJsxMode || findTsconfig(src) == "" {
+      flags = append(flags, "--jsx", tscJsxMode(c))
+    }
+  case srcOfJsx:
+    flags = append(flags, "--allowJs", "--jsx", tscJsxMode(c))
+  }
+  return append(flags, c.TscFlags...)
+}
+
+// Creates the tsc command for src. When the project has a tsconfig.json,
+// tsc is run against a config that extends it and the returned function
+// removes that config.
+func tscCommand(ctx context.Context, c *Config, src, dst string) (*exec.Cmd, func(), error) {
+  tsconfig := findTsconfig(src)
+  if tsconfig == "" {
+    args := append([]string{"--out", dst}, tscFlags(c, src)...)
+    args = append(args, src)
+    return exec.CommandContext(ctx, PathToTsc, args...), func() {}, nil
+  }
+
+  project, err := writeTsconfig(tsconfig, src, dst)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  args := append([]string{"--project", project}, tscFlags(c, src)...)
+  return exec.CommandContext(ctx, PathToTsc, args...), func() {
+    os.Remove(project)
+  }, nil
 }
 
 func CompileTsc(c *Config, src, dst string) error {
-  return tscCommand(src, dst).Run()
+  return CompileTscContext(context.Background(), c, src, dst)
+}
+
+// CompileTscContext compiles src into dst with tsc, which is killed if
+// ctx is done before it finishes. The compiler options come from the
+// nearest tsconfig.json and Config.TscFlags. src can be a .main.ts, a
+// .main.tsx or a .main.jsx in a modern Config.JsxMode.
+func CompileTscContext(ctx context.Context, c *Config, src, dst string) error {
+  if err := checkJsxMode(c, src); err != nil {
+    return err
+  }
+
+  cm, cleanup, err := tscCommand(ctx, c, src, dst)
+  if err != nil {
+    return err
+  }
+  defer cleanup()
+
+  return runCompiler(ctx, c, "tsc", src, cm, parseTscDiagnostics)
+}
+
+// tsc can only write to a file, so its output is streamed from a temp
+// file once it finishes.
+var streamTscFile = streamFromFile(CompileTscContext)
+
+// Compiles src with tsc, or with a tsc worker when they are enabled.
+func streamTsc(ctx context.Context, c *Config, src string, w io.Writer) error {
+  if err := checkJsxMode(c, src); err != nil {
+    return err
+  }
+
+  if c.Workers > 0 {
+    req := &workerRequest{
+      Src:     src,
+      Project: findTsconfig(src),
+      Flags:   tscFlags(c, src),
+    }
+    return c.workerPools().tsc.compile(ctx, c, req, w, parseTscDiagnostics)
+  }
+  return streamTscFile(ctx, c, src, w)
 }
@@ -0,0 +1,210 @@
+package pork
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindTsconfig(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"tsconfig.json":         "{}",
+		"web/a.main.ts":         "",
+		"web/app/tsconfig.json": "{}",
+		"web/app/b.main.ts":     "",
+	})
+	defer os.RemoveAll(dir)
+
+	tests := map[string]string{
+		"web/a.main.ts":     "tsconfig.json",
+		"web/app/b.main.ts": "web/app/tsconfig.json",
+	}
+	for src, expected := range tests {
+		actual := findTsconfig(filepath.Join(dir, src))
+		if actual != filepath.Join(dir, expected) {
+			t.Errorf("%s: expected %s, got %s", src, expected, actual)
+		}
+	}
+}
+
+func TestWriteTsconfig(t *testing.T) {
+	project, err := writeTsconfig("/src/tsconfig.json", "/src/a.main.ts", "/out/a.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(project)
+
+	data, err := ioutil.ReadFile(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg struct {
+		Extends         string
+		Files           []string
+		CompilerOptions map[string]interface{}
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Extends != "/src/tsconfig.json" {
+		t.Errorf("expected to extend /src/tsconfig.json, got %s", cfg.Extends)
+	}
+
+	if len(cfg.Files) != 1 || cfg.Files[0] != "/src/a.main.ts" {
+		t.Errorf("expected only /src/a.main.ts, got %v", cfg.Files)
+	}
+
+	if cfg.CompilerOptions["outFile"] != "/out/a.js" {
+		t.Errorf("expected outFile /out/a.js, got %v", cfg.CompilerOptions["outFile"])
+	}
+
+	if v, ok := cfg.CompilerOptions["outDir"]; !ok || v != nil {
+		t.Errorf("expected outDir to be cleared, got %v", v)
+	}
+}
+
+func TestTscFlags(t *testing.T) {
+	c := NewConfig(None)
+	c.TscFlags = []string{"--strict"}
+
+	tests := map[string][]string{
+		"a.main.ts":  {"--strict"},
+		"a.main.tsx": {"--jsx", "react", "--strict"},
+		"a.main.jsx": {"--allowJs", "--jsx", "react", "--strict"},
+	}
+	for src, expected := range tests {
+		if actual := tscFlags(c, src); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: expected %v, got %v", src, expected, actual)
+		}
+	}
+
+	// the tsconfig's jsx option wins unless the mode is set
+	dir := writeFiles(t, map[string]string{
+		"tsconfig.json": `{"compilerOptions": {"jsx": "preserve"}}`,
+		"a.main.tsx":    "",
+	})
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "a.main.tsx")
+	expected := []string{"--strict"}
+	if actual := tscFlags(c, src); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	c.JsxMode = "react"
+	expected = []string{"--jsx", "react", "--strict"}
+	for _, src := range []string{"a.main.tsx", src} {
+		if actual := tscFlags(c, src); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: expected %v, got %v", src, expected, actual)
+		}
+	}
+}
+
+// Stands in for tsc by writing its arguments into the file given to --out
+// and leaving a mark next to it.
+const fakeTsc = `#!/bin/sh
+for a; do
+  if [ "$prev" = --out ]; then out="$a"; fi
+  prev="$a"
+done
+touch "$(dirname "$0")/ran"
+echo "$@" > "$out"
+`
+
+// Only react compiles into a single script, so the other modes of tsc
+// fail before tsc runs.
+func TestTscJsxModes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"tsc":        fakeTsc,
+		"a.main.tsx": "var a = <div/>;\n",
+		"b.main.jsx": "var b = <div/>;\n",
+	})
+	defer os.RemoveAll(dir)
+
+	defer func(path string) {
+		PathToTsc = path
+	}(PathToTsc)
+	PathToTsc = filepath.Join(dir, "tsc")
+	if err := os.Chmod(PathToTsc, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewConfig(None)
+	c.JsxMode = "react"
+	for name, expected := range map[string]string{
+		"a.main.tsx": "--jsx react",
+		"b.main.jsx": "--allowJs --jsx react",
+	} {
+		var buf bytes.Buffer
+		if err := CompileFile(c, filepath.Join(dir, name), &buf); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("%s: expected tsc to get %s, got %s", name, expected, buf.String())
+		}
+	}
+
+	ran := filepath.Join(dir, "ran")
+	for _, mode := range []string{"react-jsx", "react-jsxdev", "preserve", "react-native"} {
+		if err := os.Remove(ran); err != nil {
+			t.Fatal(err)
+		}
+
+		c.JsxMode = mode
+		for _, name := range []string{"a.main.tsx", "b.main.jsx"} {
+			err := CompileFile(c, filepath.Join(dir, name), &bytes.Buffer{})
+			if err == nil || !strings.Contains(err.Error(), "jsx mode "+mode) {
+				t.Errorf("%s in %s: expected an error, got %v", name, mode, err)
+			}
+		}
+
+		if _, err := os.Stat(ran); err == nil {
+			t.Errorf("%s: expected tsc not to run", mode)
+		}
+		if err := ioutil.WriteFile(ran, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// x.js is answered by the first of x.main.jsx, x.main.tsx, x.main.ts and
+// x.main.js.
+func TestFindContentPriority(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.main.jsx": "", "a.main.tsx": "", "a.main.ts": "", "a.main.js": "",
+		"b.main.tsx": "", "b.main.ts": "", "b.main.js": "",
+		"c.main.ts": "", "c.main.js": "",
+		"d.main.js": "",
+	})
+	defer os.RemoveAll(dir)
+
+	tests := map[string]srcType{
+		"/a.js": srcOfJsx,
+		"/b.js": srcOfTsx,
+		"/c.js": srcOfTsc,
+		"/d.js": srcOfJs,
+	}
+	for path, expected := range tests {
+		r, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := FindContent("/", r, http.Dir(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res == nil || res.srcType != expected {
+			t.Errorf("%s: expected %d, got %+v", path, expected, res)
+		}
+	}
+}
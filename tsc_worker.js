@@ -0,0 +1,100 @@
+// A long-running TypeScript compiler for pork. Each line of stdin is a
+// JSON request and each response is written to stdout as a line of JSON.
+//
+//   request:  {"src": "/path/to/a.main.ts", "project": "/path/to/tsconfig.json",
+//              "flags": ["--strict"]}
+//   response: {"output": "...", "diagnostics": "...", "failed": false}
+//
+// Diagnostics are formatted the way tsc prints them so that pork can parse
+// them the same way. The path to the typescript module is the only
+// argument.
+'use strict';
+
+var readline = require('readline');
+var ts = require(process.argv[2] || 'typescript');
+
+function formatDiagnostic(d) {
+  var msg = ts.flattenDiagnosticMessageText(d.messageText, '\n');
+  var cat = d.category === ts.DiagnosticCategory.Error ? 'error' : 'warning';
+  if (d.file && d.start !== undefined) {
+    var pos = d.file.getLineAndCharacterOfPosition(d.start);
+    return d.file.fileName + '(' + (pos.line + 1) + ',' + (pos.character + 1) + '): ' +
+        cat + ' TS' + d.code + ': ' + msg;
+  }
+  return cat + ' TS' + d.code + ': ' + msg;
+}
+
+// Reads the compiler options from the project's tsconfig.json and any
+// extra command line flags.
+function compilerOptions(req) {
+  var options = {};
+  if (req.project) {
+    var host = Object.create(ts.sys);
+    host.onUnRecoverableConfigFileDiagnostic = function(d) {
+      throw new Error(ts.flattenDiagnosticMessageText(d.messageText, '\n'));
+    };
+    options = ts.getParsedCommandLineOfConfigFile(req.project, {}, host).options;
+  }
+
+  if (req.flags && req.flags.length) {
+    var cl = ts.parseCommandLine(req.flags);
+    if (cl.errors.length) {
+      throw new Error(ts.flattenDiagnosticMessageText(cl.errors[0].messageText, '\n'));
+    }
+    Object.keys(cl.options).forEach(function(k) {
+      options[k] = cl.options[k];
+    });
+  }
+
+  // only the output is controlled by pork
+  [
+    'outDir', 'declarationDir', 'tsBuildInfoFile'
+  ].forEach(function(k) {
+    delete options[k];
+  });
+  [
+    'noEmit', 'emitDeclarationOnly', 'declaration', 'declarationMap', 'sourceMap',
+    'inlineSourceMap', 'composite', 'incremental'
+  ].forEach(function(k) {
+    options[k] = false;
+  });
+  options.outFile = 'out.js';
+  return options;
+}
+
+// The equivalent of tsc --out out.js src, keeping the output in memory.
+function compile(req) {
+  var options = compilerOptions(req);
+  var output = '';
+
+  var host = ts.createCompilerHost(options);
+  host.writeFile = function(name, text) {
+    if (/\.js$/.test(name)) {
+      output += text;
+    }
+  };
+
+  var program = ts.createProgram([req.src], options, host);
+  var emit = program.emit();
+  var diags = ts.getPreEmitDiagnostics(program).concat(emit.diagnostics);
+
+  var failed = emit.emitSkipped || diags.some(function(d) {
+    return d.category === ts.DiagnosticCategory.Error;
+  });
+
+  return {
+    output: output,
+    diagnostics: diags.map(formatDiagnostic).join('\n'),
+    failed: failed
+  };
+}
+
+readline.createInterface({ input: process.stdin, terminal: false }).on('line', function(line) {
+  var res;
+  try {
+    res = compile(JSON.parse(line));
+  } catch (e) {
+    res = { output: '', diagnostics: 'error TS0: ' + e.message, failed: true };
+  }
+  process.stdout.write(JSON.stringify(res) + '\n');
+});
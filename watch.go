@@ -0,0 +1,129 @@
+package pork
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type fileState struct {
+	mod  time.Time
+	size int64
+}
+
+// A record of the state of every file beneath a set of directories.
+type snapshot map[string]fileState
+
+// Records the state of every file beneath roots, except for the files in
+// dest and the outputs of b (which exist in the roots when building in
+// place).
+func takeSnapshot(roots []http.Dir, dest http.Dir, b *build) (snapshot, error) {
+	s := snapshot{}
+	for _, root := range roots {
+		src := string(root)
+		if err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// files can disappear while walking
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+
+			if info.IsDir() {
+				if path != src && sameDir(path, string(dest)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if _, ok := b.outputs[path]; ok || filepath.Base(path) == manifestFileName {
+				return nil
+			}
+
+			s[path] = fileState{mod: info.ModTime(), size: info.Size()}
+			return nil
+		}); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Returns the files that were added, modified or removed between s and o.
+func (s snapshot) changes(o snapshot) []string {
+	var changed []string
+	for path, a := range s {
+		if b, ok := o[path]; !ok || a != b {
+			changed = append(changed, path)
+		}
+	}
+	for path := range o {
+		if _, ok := s[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Assets that stylesheets commonly embed with datauri().
+var dataURIAssetExtensions = []string{
+	".png", ".gif", ".jpg", ".jpeg", ".svg", ".webp", ".woff", ".woff2", ".ico",
+}
+
+func isDataURIAsset(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range dataURIAssetExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Determines which files need to be rebuilt after the given files have
+// changed. A changed source is rebuilt along with every source that might
+// refer to it; a changed partial or include rebuilds all of the sources
+// of the same kind and an image or font (which may be embedded with
+// datauri()) is copied and rebuilds all stylesheets. A changed
+// tsconfig.json rebuilds everything tsc may compile.
+func affectedBy(changed []string) func(string) bool {
+	files := map[string]bool{}
+	types := map[srcType]bool{}
+	for _, path := range changed {
+		files[path] = true
+		if typeOfSrc(path) != srcOfUnknown {
+			continue
+		}
+
+		switch {
+		case filepath.Base(path) == tsconfigFileName:
+			types[srcOfTsc] = true
+			types[srcOfTsx] = true
+			types[srcOfJsx] = true
+		case strings.HasSuffix(path, ".scss"), strings.HasSuffix(path, ".css"):
+			types[srcOfScss] = true
+			types[srcOfList] = true
+		case strings.HasSuffix(path, ".ts"), strings.HasSuffix(path, ".tsx"):
+			types[srcOfTsc] = true
+			types[srcOfTsx] = true
+		case strings.HasSuffix(path, ".jsx"):
+			types[srcOfJsx] = true
+			types[srcOfTsx] = true
+		case strings.HasSuffix(path, ".js"):
+			types[srcOfJs] = true
+			types[srcOfModules] = true
+			types[srcOfList] = true
+		case isDataURIAsset(path):
+			types[srcOfScss] = true
+		}
+	}
+
+	return func(path string) bool {
+		return files[path] || types[typeOfSrc(path)]
+	}
+}
@@ -0,0 +1,226 @@
+package pork
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSnapshotChanges(t *testing.T) {
+	now := time.Now()
+	a := snapshot{
+		"same":     {mod: now, size: 1},
+		"modified": {mod: now, size: 1},
+		"resized":  {mod: now, size: 1},
+		"removed":  {mod: now, size: 1},
+	}
+	b := snapshot{
+		"same":     {mod: now, size: 1},
+		"modified": {mod: now.Add(time.Second), size: 1},
+		"resized":  {mod: now, size: 2},
+		"added":    {mod: now, size: 1},
+	}
+
+	expected := []string{"added", "modified", "removed", "resized"}
+	if changed := a.changes(b); !reflect.DeepEqual(changed, expected) {
+		t.Fatalf("expected %v, got %v", expected, changed)
+	}
+
+	if changed := a.changes(a); len(changed) != 0 {
+		t.Fatalf("expected no changes, got %v", changed)
+	}
+}
+
+func TestAffectedBy(t *testing.T) {
+	sources := []string{
+		"a.main.js",
+		"b.main.js",
+		"c.main.scss",
+		"d.main.ts",
+		"e.main.tsx",
+		"f.main.jsx",
+		"g.main.list",
+		"h.main.modules",
+	}
+
+	tests := []struct {
+		changed  string
+		expected []string
+	}{
+		{"a.main.js", []string{"a.main.js"}},
+		{"lib/util.js", []string{"a.main.js", "b.main.js", "g.main.list", "h.main.modules"}},
+		{"lib/_vars.scss", []string{"c.main.scss", "g.main.list"}},
+		{"lib/reset.css", []string{"c.main.scss", "g.main.list"}},
+		{"img/logo.PNG", []string{"c.main.scss"}},
+		{"lib/util.ts", []string{"d.main.ts", "e.main.tsx"}},
+		{"lib/view.jsx", []string{"e.main.tsx", "f.main.jsx"}},
+		{tsconfigFileName, []string{"d.main.ts", "e.main.tsx", "f.main.jsx"}},
+		{"page.html", nil},
+	}
+
+	for _, test := range tests {
+		affected := affectedBy([]string{test.changed})
+
+		var res []string
+		for _, src := range sources {
+			if affected(src) {
+				res = append(res, src)
+			}
+		}
+
+		if !reflect.DeepEqual(res, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.changed, test.expected, res)
+		}
+	}
+}
+
+// Removes the outputs in out so that the ones a refresh builds can be
+// told apart from the ones it leaves alone.
+func removeOutputs(t *testing.T, out string, names []string) {
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(out, name)); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Returns which of the outputs named exist in out.
+func builtOutputs(out string, names []string) []string {
+	var res []string
+	for _, name := range names {
+		if exists(filepath.Join(out, name)) {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func changeFile(t *testing.T, path, data string) {
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A stand-in for tsc that writes to the output named by --out or by the
+// outFile of the --project it is given.
+const fakeProjectTsc = `#!/bin/sh
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --out) out="$2";;
+    --project) out=$(sed 's/.*"outFile":"\([^"]*\)".*/\1/' "$2");;
+  esac
+  shift
+done
+echo "var compiled;" > "$out"
+`
+
+// Productionize, change the sources and refresh: the refresh rebuilds only
+// what the changes affect, unless the build before it failed.
+func TestProductionizeRefresh(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"tsc":                     fakeProjectTsc,
+		"src/a.main.js":           "#include \"util.js\"\nvar a;\n",
+		"src/b.main.js":           "var b;\n",
+		"src/c.main.ts":           "var c = 1;\n",
+		"src/page.html":           "<html></html>",
+		"inc/lib.js":              "var lib;\n",
+		"src/d.main.js":           "#include \"lib.js\"\nvar d;\n",
+		"src/" + tsconfigFileName: "{}",
+	})
+	defer os.RemoveAll(dir)
+
+	defer func(path string) {
+		PathToTsc = path
+	}(PathToTsc)
+	PathToTsc = filepath.Join(dir, "tsc")
+	if err := os.Chmod(PathToTsc, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(dir, "src")
+	inc := filepath.Join(dir, "inc")
+	// the output dir is inside the root, so writing to it must not look
+	// like a change
+	out := filepath.Join(src, "out")
+	outputs := []string{"a.js", "b.js", "c.js", "d.js"}
+
+	c := NewConfig(None)
+	c.JsIncludes = []string{inc}
+
+	// util.js is missing, so the first build fails
+	refresh, err := Content(c, http.Dir(src)).Productionize(http.Dir(out))
+	if err == nil {
+		t.Fatal("expected the build to fail without util.js")
+	}
+	if refresh == nil {
+		t.Fatal("expected a refresh function after a failed build")
+	}
+
+	removeOutputs(t, out, outputs)
+
+	refreshes := []struct {
+		name     string
+		change   func()
+		expected []string
+	}{
+		{
+			// the failed build may be incomplete, so everything is rebuilt
+			name: "after a failure",
+			change: func() {
+				changeFile(t, filepath.Join(src, "util.js"), "var util;\n")
+			},
+			expected: outputs,
+		},
+		{
+			name:     "nothing changed",
+			change:   func() {},
+			expected: nil,
+		},
+		{
+			name: "a source changed",
+			change: func() {
+				changeFile(t, filepath.Join(src, "b.main.js"), "var b = 2;\n")
+			},
+			expected: []string{"b.js"},
+		},
+		{
+			name: "an include dir changed",
+			change: func() {
+				changeFile(t, filepath.Join(inc, "lib.js"), "var lib = 2;\n")
+			},
+			expected: []string{"a.js", "b.js", "d.js"},
+		},
+		{
+			name: "tsconfig.json changed",
+			change: func() {
+				changeFile(t, filepath.Join(src, tsconfigFileName), `{"compilerOptions": {}}`)
+			},
+			expected: []string{"c.js"},
+		},
+		{
+			name: "a page changed",
+			change: func() {
+				changeFile(t, filepath.Join(src, "page.html"), "<html><body></body></html>")
+			},
+			expected: nil,
+		},
+	}
+
+	for _, r := range refreshes {
+		r.change()
+		if err := refresh(); err != nil {
+			t.Fatalf("%s: %s", r.name, err)
+		}
+
+		if built := builtOutputs(out, outputs); !reflect.DeepEqual(built, r.expected) {
+			t.Errorf("%s: expected %v to be built, got %v", r.name, r.expected, built)
+		}
+		removeOutputs(t, out, outputs)
+	}
+}
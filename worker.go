@@ -0,0 +1,390 @@
+package pork
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// PathToRuby is the ruby used to run the sass worker.
+var PathToRuby = "ruby"
+
+// PathToNode is the node used to run the tsc worker.
+var PathToNode = "node"
+
+// Returned by a worker when the compile itself failed.
+var errWorkerCompile = errors.New("compile failed")
+
+func pathToSassWorker() string {
+	return filepath.Join(rootDir, "sass_worker.rb")
+}
+
+func pathToTscWorker() string {
+	return filepath.Join(rootDir, "tsc_worker.js")
+}
+
+// Finds the typescript module that PathToTsc belongs to. The tsc command
+// lives in the bin directory of the module (node_modules/.bin/tsc is a
+// link to it).
+func pathToTypeScript() (string, error) {
+	tsc, err := exec.LookPath(PathToTsc)
+	if err != nil {
+		return "", err
+	}
+
+	tsc, err = filepath.EvalSymlinks(tsc)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Dir(filepath.Dir(tsc)), nil
+}
+
+type workerRequest struct {
+	Src       string   `json:"src"`
+	Style     string   `json:"style,omitempty"`
+	LoadPaths []string `json:"load_paths,omitempty"`
+	Project   string   `json:"project,omitempty"`
+	Flags     []string `json:"flags,omitempty"`
+
+	// The full command line, for workers that take one.
+	Args []string `json:"args,omitempty"`
+}
+
+type workerResponse struct {
+	Output      string `json:"output"`
+	Diagnostics string `json:"diagnostics"`
+	Failed      bool   `json:"failed"`
+}
+
+// How requests are written to a worker and responses read back.
+type workerCodec interface {
+	encode(req *workerRequest) ([]byte, error)
+	decode(r *bufio.Reader) (*workerResponse, error)
+}
+
+// The protocol of the sass and tsc workers: a line of JSON in each
+// direction.
+type jsonCodec struct{}
+
+func (jsonCodec) encode(req *workerRequest) ([]byte, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (jsonCodec) decode(r *bufio.Reader) (*workerResponse, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	var res workerResponse
+	if err := json.Unmarshal(line, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// The persistent worker protocol that closure-compiler speaks when it is
+// run with --persistent_worker: WorkRequest and WorkResponse protocol
+// buffers, each preceded by its length as a varint. Only the fields that
+// pork needs are handled.
+//
+//	message WorkRequest { repeated string arguments = 1; }
+//	message WorkResponse { int32 exit_code = 1; string output = 2; }
+type workProtocolCodec struct{}
+
+func (workProtocolCodec) encode(req *workerRequest) ([]byte, error) {
+	var msg []byte
+	for _, arg := range req.Args {
+		// field 1, length delimited
+		msg = append(msg, 1<<3|2)
+		msg = binary.AppendUvarint(msg, uint64(len(arg)))
+		msg = append(msg, arg...)
+	}
+	return append(binary.AppendUvarint(nil, uint64(len(msg))), msg...), nil
+}
+
+var errBadWorkResponse = errors.New("malformed WorkResponse")
+
+func (workProtocolCodec) decode(r *bufio.Reader) (*workerResponse, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+
+	var res workerResponse
+	for len(msg) > 0 {
+		key, k := binary.Uvarint(msg)
+		if k <= 0 {
+			return nil, errBadWorkResponse
+		}
+		msg = msg[k:]
+
+		v, k := binary.Uvarint(msg)
+		if k <= 0 {
+			return nil, errBadWorkResponse
+		}
+		msg = msg[k:]
+
+		switch key & 7 {
+		case 0:
+			if key>>3 == 1 {
+				res.Failed = v != 0
+			}
+		case 2:
+			if uint64(len(msg)) < v {
+				return nil, errBadWorkResponse
+			}
+			if key>>3 == 2 {
+				res.Diagnostics = string(msg[:v])
+			}
+			msg = msg[v:]
+		default:
+			return nil, errBadWorkResponse
+		}
+	}
+	return &res, nil
+}
+
+// A long-running compiler process that answers each request it reads on
+// stdin with a response on stdout.
+type worker struct {
+	name    string
+	codec   workerCodec
+	command func() (*exec.Cmd, error)
+
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+func (w *worker) start() error {
+	cm, err := w.command()
+	if err != nil {
+		return err
+	}
+
+	in, err := cm.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	out, err := cm.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	cm.Stderr = os.Stderr
+	if err := cm.Start(); err != nil {
+		return err
+	}
+
+	w.cmd, w.in, w.out = cm, in, bufio.NewReader(out)
+	return nil
+}
+
+func (w *worker) stop() {
+	if w.cmd == nil {
+		return
+	}
+
+	w.in.Close()
+	w.cmd.Process.Kill()
+	w.cmd.Wait()
+	w.cmd, w.in, w.out = nil, nil, nil
+}
+
+func (w *worker) roundTrip(ctx context.Context, req []byte) (*workerResponse, error) {
+	type result struct {
+		res *workerResponse
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		if _, err := w.in.Write(req); err != nil {
+			done <- result{err: err}
+			return
+		}
+
+		res, err := w.codec.decode(w.out)
+		done <- result{res: res, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.res, r.err
+	case <-ctx.Done():
+		// the only way to abandon a request is to kill the process
+		w.cmd.Process.Kill()
+		<-done
+		return nil, ctx.Err()
+	}
+}
+
+// Sends a request to the worker, starting it if it is not running. A
+// worker that has crashed is restarted and the request is tried again.
+func (w *worker) call(ctx context.Context, req *workerRequest) (*workerResponse, error) {
+	data, err := w.codec.encode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if w.cmd == nil {
+			if err := w.start(); err != nil {
+				return nil, fmt.Errorf("%s worker: %s", w.name, err)
+			}
+		}
+
+		res, err := w.roundTrip(ctx, data)
+		if err == nil {
+			return res, nil
+		}
+
+		w.stop()
+
+		if ctx.Err() != nil {
+			return nil, contextError(ctx, w.name)
+		}
+
+		if attempt > 0 {
+			return nil, fmt.Errorf("%s worker: %s", w.name, err)
+		}
+	}
+}
+
+// A fixed number of workers for a single tool. Each worker handles one
+// request at a time.
+type workerPool struct {
+	name    string
+	workers []*worker
+	idle    chan *worker
+}
+
+func newWorkerPool(name string, size int, codec workerCodec,
+	command func() (*exec.Cmd, error)) *workerPool {
+	p := &workerPool{
+		name: name,
+		idle: make(chan *worker, size),
+	}
+
+	for i := 0; i < size; i++ {
+		w := &worker{name: name, codec: codec, command: command}
+		p.workers = append(p.workers, w)
+		p.idle <- w
+	}
+	return p
+}
+
+// Sends a request to the next idle worker.
+func (p *workerPool) call(ctx context.Context, req *workerRequest) (*workerResponse, error) {
+	var w *worker
+	select {
+	case w = <-p.idle:
+	case <-ctx.Done():
+		return nil, contextError(ctx, p.name)
+	}
+	defer func() { p.idle <- w }()
+
+	return w.call(ctx, req)
+}
+
+// Compiles src with the next idle worker and writes the output to out. Any
+// warnings are reported as runCompiler reports them.
+func (p *workerPool) compile(ctx context.Context, c *Config, req *workerRequest, out io.Writer,
+	parse diagParser) error {
+	res, err := p.call(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if res.Failed {
+		return newCompileError(p.name, req.Src, res.Diagnostics, errWorkerCompile, parse)
+	}
+
+	if res.Diagnostics != "" {
+		logWarnings(c, req.Src, res.Diagnostics+"\n", warningsOf(parse(res.Diagnostics)))
+	}
+
+	_, err = io.WriteString(out, res.Output)
+	return err
+}
+
+// Stops every worker, waiting for any requests in progress to finish. The
+// workers start again if the pool is used after it is closed.
+func (p *workerPool) close() {
+	for range p.workers {
+		w := <-p.idle
+		w.stop()
+	}
+
+	for _, w := range p.workers {
+		p.idle <- w
+	}
+}
+
+// The worker pools for a Config, which are started on first use.
+type workerPools struct {
+	once sync.Once
+	sass *workerPool
+	tsc  *workerPool
+	jsc  *workerPool
+}
+
+func (c *Config) workerPools() *workerPools {
+	c.workers.once.Do(func() {
+		c.workers.sass = newWorkerPool("sass", c.Workers, jsonCodec{}, func() (*exec.Cmd, error) {
+			return exec.Command(PathToRuby, pathToSassWorker()), nil
+		})
+
+		c.workers.tsc = newWorkerPool("tsc", c.Workers, jsonCodec{}, func() (*exec.Cmd, error) {
+			ts, err := pathToTypeScript()
+			if err != nil {
+				return nil, err
+			}
+			return exec.Command(PathToNode, pathToTscWorker(), ts), nil
+		})
+
+		c.workers.jsc = newWorkerPool("closure-compiler", c.Workers, workProtocolCodec{},
+			func() (*exec.Cmd, error) {
+				jsc, err := closureCompiler()
+				if err != nil {
+					return nil, err
+				}
+				args := append(jsc[1:], "--persistent_worker")
+				return exec.Command(jsc[0], args...), nil
+			})
+	})
+	return &c.workers
+}
+
+// Close stops any compiler workers that were started for this Config.
+func (c *Config) Close() error {
+	if c.Workers <= 0 {
+		return nil
+	}
+
+	p := c.workerPools()
+	p.sass.close()
+	p.tsc.close()
+	p.jsc.close()
+	return nil
+}
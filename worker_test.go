@@ -0,0 +1,195 @@
+package pork
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// Writes a shell script that acts as a worker and returns a pool that
+// runs it.
+func scriptPool(t *testing.T, script string) (*workerPool, func()) {
+	dir, err := ioutil.TempDir(os.TempDir(), "pork-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "worker")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\ncd "+dir+"\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newWorkerPool("test", 1, jsonCodec{}, func() (*exec.Cmd, error) {
+		return exec.Command(path), nil
+	})
+
+	return p, func() {
+		p.close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	p, done := scriptPool(t, `while read -r line; do
+  case "$line" in
+    *bad*) echo '{"failed": true, "diagnostics": "a.ts(1,2): error TS1005: oops"}';;
+    *warn*) echo '{"output": "ok", "diagnostics": "warn.ts(3,4): warning TS6385: old"}';;
+    *) echo '{"output": "ok"}';;
+  esac
+done
+`)
+	defer done()
+
+	var logged []Diagnostic
+	c := NewConfig(None)
+	c.WarningLogger = func(src string, warnings []Diagnostic) {
+		logged = append(logged, warnings...)
+	}
+
+	for i := 0; i < 3; i++ {
+		var buf bytes.Buffer
+		if err := p.compile(context.Background(), c, &workerRequest{Src: "a.ts"}, &buf, parseTscDiagnostics); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != "ok" {
+			t.Fatalf("expected ok, got %q", buf.String())
+		}
+	}
+
+	err := p.compile(context.Background(), c, &workerRequest{Src: "bad.ts"}, ioutil.Discard, parseTscDiagnostics)
+	var ce *CompileError
+	if !errors.As(err, &ce) || len(ce.Diagnostics) != 1 || ce.Diagnostics[0].Line != 1 {
+		t.Fatalf("expected a compile error, got %v", err)
+	}
+
+	// the warnings of a compile that succeeds go to the WarningLogger
+	if err := p.compile(context.Background(), c, &workerRequest{Src: "warn.ts"}, ioutil.Discard, parseTscDiagnostics); err != nil {
+		t.Fatal(err)
+	}
+	if len(logged) != 1 || logged[0].Line != 3 || logged[0].Severity != SeverityWarning {
+		t.Fatalf("expected one warning to be logged, got %+v", logged)
+	}
+}
+
+func TestWorkerPoolRestart(t *testing.T) {
+	// the first process crashes without answering, the second one works
+	p, done := scriptPool(t, `if [ ! -f started ]; then
+  touch started
+  exit 1
+fi
+while read -r line; do
+  echo '{"output": "ok"}'
+done
+`)
+	defer done()
+
+	c := NewConfig(None)
+	var buf bytes.Buffer
+	if err := p.compile(context.Background(), c, &workerRequest{Src: "a.scss"}, &buf, parseSassDiagnostics); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != "ok" {
+		t.Fatalf("expected ok, got %q", buf.String())
+	}
+}
+
+func TestWorkerPoolCancel(t *testing.T) {
+	p, done := scriptPool(t, "exec sleep 10\n")
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := p.compile(ctx, NewConfig(None), &workerRequest{Src: "a.scss"}, ioutil.Discard, parseSassDiagnostics)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+// Closing a Content handler stops the workers it started.
+func TestContentClose(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"sass":        "#!/bin/sh\necho 'Ruby Sass 3.7.4'\n",
+		"ruby":        "#!/bin/sh\nwhile read -r line; do echo '{\"output\": \"a{b:c}\"}'; done\n",
+		"a.main.scss": ".a { b: c; }\n",
+	})
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"sass", "ruby"} {
+		if err := os.Chmod(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(sass, ruby string) {
+		PathToSass, PathToRuby = sass, ruby
+	}(PathToSass, PathToRuby)
+	PathToSass = filepath.Join(dir, "sass")
+	PathToRuby = filepath.Join(dir, "ruby")
+
+	c := NewConfig(None)
+	c.Workers = 1
+
+	h := Content(c, http.Dir(dir))
+	r := NewRouter(nil, nil, nil)
+	r.RespondWith("/", h)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/a.css", nil))
+	if w.Body.String() != "a{b:c}" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+
+	cmd := c.workerPools().sass.workers[0].cmd
+	if cmd == nil {
+		t.Fatal("expected a sass worker to be running")
+	}
+	pid := cmd.Process.Pid
+
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+		t.Fatalf("expected worker %d to be gone, got %v", pid, err)
+	}
+}
+
+func TestWorkProtocolCodec(t *testing.T) {
+	data, err := (workProtocolCodec{}).encode(&workerRequest{Args: []string{"--js", "a.js"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte("\x0c\x0a\x04--js\x0a\x04a.js")
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("expected %q, got %q", expected, data)
+	}
+
+	// exit_code = 1, output = "oops", then an unknown varint field
+	msg := "\x08\x01\x12\x04oops\x18\x07"
+	res, err := (workProtocolCodec{}).decode(bufio.NewReader(bytes.NewBufferString(
+		string(rune(len(msg))) + msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !res.Failed || res.Diagnostics != "oops" {
+		t.Fatalf("expected a failure with oops, got %+v", res)
+	}
+
+	if _, err := (workProtocolCodec{}).decode(bufio.NewReader(bytes.NewBufferString("\x03\x12\x09a"))); err == nil {
+		t.Fatal("expected an error for a truncated response")
+	}
+}